@@ -0,0 +1,35 @@
+package statemachine
+
+// OnEntry registers fn as an entry action of state. Entry actions run every
+// time the machine enters state, whichever event caused it:
+//
+// - on a committed transition, right after the state has changed and before
+// the enter_ handlers,
+//
+// - on a self-transition, which calls no handlers at all,
+//
+// - on Restore into state.
+//
+// Unlike enter_ handlers, entry actions do not receive the event and cannot
+// cancel or redirect it. They are not run for the initial state when the
+// machine is constructed. Several actions of one state run in the order they
+// were registered.
+func (machine *StateMachine) OnEntry(state string, fn func()) {
+	machine.entry[state] = append(machine.entry[state], fn)
+}
+
+// OnExit registers fn as an exit action of state. Exit actions run every time
+// the machine leaves state when a transition is committed, right before the
+// state changes, and on a self-transition before the entry actions. They run
+// after the leave_ handlers, so they are not run for transitions cancelled in
+// leave_, and for an asynchronous transition they run from Excute.
+func (machine *StateMachine) OnExit(state string, fn func()) {
+	machine.exit[state] = append(machine.exit[state], fn)
+}
+
+// runActions calls actions in order.
+func (machine *StateMachine) runActions(actions []func()) {
+	for _, action := range actions {
+		action()
+	}
+}
@@ -0,0 +1,69 @@
+package statemachine
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestEntryExitActions(t *testing.T) {
+	var calls []string
+	fsm := NewStateMachine(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+			{Name: "stay", Src: []string{"end"}, Dst: "end"},
+		},
+		Handlers{
+			"enter_end": func(e *Event) {
+				calls = append(calls, "enter_end")
+			},
+			"leave_start": func(e *Event) {
+				calls = append(calls, "leave_start")
+			},
+		},
+	)
+	fsm.OnExit("start", func() {
+		calls = append(calls, "exit start")
+	})
+	fsm.OnEntry("end", func() {
+		calls = append(calls, "entry end")
+	})
+	fsm.OnExit("end", func() {
+		calls = append(calls, "exit end")
+	})
+
+	fsm.Event("run")
+	fsm.Event("stay")
+
+	expected := []string{"leave_start", "exit start", "entry end", "enter_end", "exit end", "entry end"}
+	if fmt.Sprint(calls) != fmt.Sprint(expected) {
+		t.Fatalf("expected %v, got %v", expected, calls)
+	}
+}
+
+func TestEntryActionOnRestore(t *testing.T) {
+	fsm := NewStateMachine(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+		},
+		Handlers{},
+	)
+	entered := 0
+	fsm.OnEntry("end", func() {
+		entered++
+	})
+
+	if err := fsm.Restore(Snapshot{Current: "end"}); err != nil {
+		t.Fatal(err)
+	}
+	if entered != 1 {
+		t.Fatalf("expected the entry action to run once, got %d", entered)
+	}
+	if err := fsm.Restore(Snapshot{Current: "start"}); err != nil {
+		t.Fatal(err)
+	}
+	if entered != 1 {
+		t.Fatalf("expected the entry action to run once, got %d", entered)
+	}
+}
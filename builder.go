@@ -0,0 +1,82 @@
+package statemachine
+
+import (
+	"fmt"
+)
+
+// Builder assembles a StateMachine step by step. It is an alternative to
+// passing large Events and Handlers literals to NewStateMachine.
+type Builder struct {
+	initial  string
+	events   Events
+	handlers []namedHandler
+}
+
+// namedHandler is a handler together with the name it was registered under.
+type namedHandler struct {
+	name    string
+	handler Handler
+}
+
+// NewBuilder returns an empty Builder.
+func NewBuilder() *Builder {
+	return &Builder{}
+}
+
+// Initial sets the state the machine starts in.
+func (b *Builder) Initial(state string) *Builder {
+	b.initial = state
+	return b
+}
+
+// Transition adds an event that moves the machine from any of src to dst.
+func (b *Builder) Transition(event, dst string, src ...string) *Builder {
+	b.events = append(b.events, EventDesc{Name: event, Src: src, Dst: dst})
+	return b
+}
+
+// Terminal adds a transition like Transition whose destination is an
+// intended dead end, see EventDesc.Terminal.
+func (b *Builder) Terminal(event, dst string, src ...string) *Builder {
+	b.events = append(b.events, EventDesc{Name: event, Src: src, Dst: dst, Terminal: true})
+	return b
+}
+
+// On registers a handler. The hook name is parsed the same way as the keys
+// of Handlers in NewStateMachine.
+func (b *Builder) On(hook string, handler Handler) *Builder {
+	b.handlers = append(b.handlers, namedHandler{hook, handler})
+	return b
+}
+
+// Build constructs the StateMachine.
+//
+// It returns an error if two handlers resolve to the same hook, for example
+// "end" and "enter_end", or if NewCheckedStateMachine rejects the machine.
+func (b *Builder) Build() (*StateMachine, error) {
+	allEvents := make(map[string]bool)
+	allStates := make(map[string]bool)
+	for _, event := range b.events {
+		for _, src := range event.Src {
+			allStates[src] = true
+			allStates[event.Dst] = true
+		}
+		allEvents[event.Name] = true
+	}
+
+	handlers := make(Handlers)
+	registered := make(map[handlerKey]string)
+	for _, h := range b.handlers {
+		if key, ok := resolveHandler(h.name, allEvents, allStates); ok {
+			if other, ok := registered[key]; ok {
+				return nil, fmt.Errorf("handler %s duplicates handler %s", h.name, other)
+			}
+			registered[key] = h.name
+		} else if _, ok := handlers[h.name]; ok {
+			return nil, fmt.Errorf("handler %s duplicates handler %s", h.name, h.name)
+		}
+		handlers[h.name] = h.handler
+	}
+
+	return NewCheckedStateMachine(b.initial, b.events, handlers)
+}
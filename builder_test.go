@@ -0,0 +1,82 @@
+package statemachine
+
+import (
+	"testing"
+)
+
+func TestBuilderMatchesLiteral(t *testing.T) {
+	var literalCalls, builderCalls []string
+
+	literal := NewStateMachine(
+		"green",
+		Events{
+			{Name: "warn", Src: []string{"green"}, Dst: "yellow"},
+			{Name: "panic", Src: []string{"yellow"}, Dst: "red"},
+			{Name: "panic", Src: []string{"green"}, Dst: "red"},
+			{Name: "calm", Src: []string{"red"}, Dst: "yellow"},
+			{Name: "clear", Src: []string{"yellow"}, Dst: "green"},
+		},
+		Handlers{
+			"enter_state": func(e *Event) {
+				literalCalls = append(literalCalls, e.Dst)
+			},
+		},
+	)
+
+	built, err := NewBuilder().
+		Initial("green").
+		Transition("warn", "yellow", "green").
+		Transition("panic", "red", "yellow").
+		Transition("panic", "red", "green").
+		Transition("calm", "yellow", "red").
+		Transition("clear", "green", "yellow").
+		On("enter_state", func(e *Event) {
+			builderCalls = append(builderCalls, e.Dst)
+		}).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, event := range []string{"warn", "warn", "panic", "calm", "clear", "calm"} {
+		literalErr := literal.Event(event)
+		builtErr := built.Event(event)
+		if (literalErr == nil) != (builtErr == nil) {
+			t.Fatalf("event %s: literal error %v, builder error %v", event, literalErr, builtErr)
+		}
+		if literal.Current() != built.Current() {
+			t.Fatalf("event %s: literal in %s, builder in %s", event, literal.Current(), built.Current())
+		}
+	}
+	if len(literalCalls) != len(builderCalls) {
+		t.Fatalf("literal handlers ran %d times, builder handlers %d times", len(literalCalls), len(builderCalls))
+	}
+}
+
+func TestBuilderUnknownInitial(t *testing.T) {
+	_, err := NewBuilder().
+		Initial("blue").
+		Transition("warn", "yellow", "green").
+		Build()
+	if err == nil || err.Error() != "initial state blue is not a known state" {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestBuilderDuplicateHandler(t *testing.T) {
+	_, err := NewBuilder().
+		Initial("start").
+		Transition("run", "end", "start").
+		On("end", func(e *Event) {}).
+		On("enter_end", func(e *Event) {}).
+		Build()
+	if err == nil || err.Error() != "handler enter_end duplicates handler end" {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestBuilderSinkState(t *testing.T) {
+	if _, err := NewBuilder().Initial("start").Transition("run", "end", "start").Build(); err != nil {
+		t.Fatal(err)
+	}
+}
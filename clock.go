@@ -0,0 +1,34 @@
+package statemachine
+
+import (
+	"time"
+)
+
+// Clock is the source of time for the time based features of the machine,
+// such as the timestamps of the history and AfterDelay. It can be replaced
+// with SetClock, for example by a fake clock in tests.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+
+	// After returns a channel that receives the current time once d has
+	// elapsed, like time.After.
+	After(d time.Duration) <-chan time.Time
+}
+
+// realClock is the default Clock, backed by the time package.
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// SetClock makes the machine take the time from c instead of the system clock.
+// It should be called before any timers are started.
+func (machine *StateMachine) SetClock(c Clock) {
+	machine.clock = c
+}
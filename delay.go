@@ -0,0 +1,106 @@
+package statemachine
+
+import (
+	"sync"
+	"time"
+)
+
+// AfterDelay makes the machine fire event once it has been in state for d.
+// The timer is started every time state is entered, as described for
+// OnEntry, and stopped when state is left, so a self-transition restarts it.
+// The event is not fired if the machine has left state in the meantime in
+// any way, including SetState and Restore, even if it has come back since.
+// Errors of the event, for example because a guard rejects it, are reported
+// to the OnReject callback.
+//
+// The event is fired from a separate goroutine while holding the lock
+// returned by Locker. The machine is not safe for concurrent use, so every
+// other goroutine driving a machine with delayed events must hold that lock
+// around its calls.
+func (machine *StateMachine) AfterDelay(state string, d time.Duration, event string) {
+	timer := &delay{state: state, after: d, event: event}
+	machine.delays[state] = append(machine.delays[state], timer)
+	machine.OnEntry(state, func() {
+		if timer.stop != nil {
+			// The state was forced with SetState without being left.
+			close(timer.stop)
+		}
+		timer.stop = make(chan struct{})
+		machine.startTimer(timer, timer.stop)
+	})
+	machine.OnExit(state, func() {
+		if timer.stop != nil {
+			close(timer.stop)
+			timer.stop = nil
+		}
+	})
+}
+
+// delay is a timer registered with AfterDelay. State is guarded by stateMu,
+// since RenameState changes it while a timer may be running.
+type delay struct {
+	state string
+	after time.Duration
+	event string
+
+	// stop is closed when state is left, nil while the machine is not in it.
+	stop chan struct{}
+}
+
+// Locker returns the lock held by the timers of AfterDelay while they fire
+// their event. Goroutines that drive a machine with delayed events must hold
+// it around their calls, such as Event and Excute, so that they never run at
+// the same time as a timer. Handlers must not take it, since they already
+// run under it when the event comes from a timer.
+func (machine *StateMachine) Locker() sync.Locker {
+	return &machine.driveMu
+}
+
+// startTimer fires the event of timer after its delay unless stop is closed
+// first or the machine is no longer in its state from the entry the timer was
+// started for.
+func (machine *StateMachine) startTimer(timer *delay, stop chan struct{}) {
+	entry := machine.entries
+	elapsed := machine.clock.After(timer.after)
+	go func() {
+		select {
+		case <-stop:
+			return
+		case <-elapsed:
+		}
+
+		machine.driveMu.Lock()
+		defer machine.driveMu.Unlock()
+		select {
+		case <-stop:
+			// Both happened; leaving the state wins.
+			return
+		default:
+		}
+		machine.stateMu.RLock()
+		entered := machine.current == timer.state && machine.entries == entry
+		machine.stateMu.RUnlock()
+		if entered {
+			machine.Event(timer.event)
+		}
+	}()
+}
+
+// Debounce makes the machine ignore event for window after each transition
+// it caused: firing it again within window returns a DebouncedError without
+// calling any handlers. The window is measured with the clock of the machine
+// and starts when the transition is committed. Failed or cancelled attempts
+// do not start a window.
+func (machine *StateMachine) Debounce(event string, window time.Duration) {
+	machine.debounce[machine.canonical(event)] = window
+}
+
+// debounced returns true if event is fired within its Debounce window.
+func (machine *StateMachine) debounced(event string) bool {
+	window, ok := machine.debounce[event]
+	if !ok {
+		return false
+	}
+	last, ok := machine.lastCommitted[event]
+	return ok && machine.clock.Now().Sub(last) < window
+}
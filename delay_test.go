@@ -0,0 +1,179 @@
+package statemachine
+
+import (
+	"testing"
+	"time"
+)
+
+func newDelayMachine(clock Clock) *StateMachine {
+	fsm := NewStateMachine(
+		"idle",
+		Events{
+			{Name: "start", Src: []string{"idle"}, Dst: "waiting"},
+			{Name: "answer", Src: []string{"waiting"}, Dst: "done"},
+			{Name: "timeout", Src: []string{"waiting"}, Dst: "expired"},
+		},
+		Handlers{},
+	)
+	fsm.SetClock(clock)
+	fsm.AfterDelay("waiting", 30*time.Second, "timeout")
+	return fsm
+}
+
+func TestAfterDelay(t *testing.T) {
+	clock := newFakeClock()
+	fsm := newDelayMachine(clock)
+	fsm.Event("start")
+
+	clock.Advance(29 * time.Second)
+	if fsm.Current() != "waiting" {
+		t.Fatalf("expected waiting, got %s", fsm.Current())
+	}
+	clock.Advance(time.Second)
+	fsm.WaitForState("expired")
+}
+
+func TestAfterDelayLeft(t *testing.T) {
+	clock := newFakeClock()
+	fsm := newDelayMachine(clock)
+	fsm.Event("start")
+	fsm.Event("answer")
+
+	clock.Advance(time.Minute)
+	time.Sleep(10 * time.Millisecond)
+	if fsm.Current() != "done" {
+		t.Fatalf("expected done, got %s", fsm.Current())
+	}
+}
+
+func TestAfterDelayRenamed(t *testing.T) {
+	clock := newFakeClock()
+	fsm := newDelayMachine(clock)
+	fsm.Event("start")
+	if err := fsm.RenameState("waiting", "pending"); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(30 * time.Second)
+	fsm.WaitForState("expired")
+
+	clock = newFakeClock()
+	fsm = newDelayMachine(clock)
+	if err := fsm.RenameState("waiting", "pending"); err != nil {
+		t.Fatal(err)
+	}
+	fsm.Event("start")
+	clock.Advance(30 * time.Second)
+	fsm.WaitForState("expired")
+}
+
+func TestAfterDelayForced(t *testing.T) {
+	clock := newFakeClock()
+	fsm := NewStateMachine(
+		"idle",
+		Events{
+			{Name: "start", Src: []string{"idle"}, Dst: "waiting"},
+			{Name: "pause", Src: []string{"waiting"}, Dst: "paused"},
+			{Name: "timeout", Src: []string{"waiting", "paused"}, Dst: "expired"},
+		},
+		Handlers{},
+	)
+	fsm.SetClock(clock)
+	fsm.AfterDelay("waiting", 30*time.Second, "timeout")
+	fsm.Event("start")
+	if err := fsm.SetState("paused"); err != nil {
+		t.Fatal(err)
+	}
+
+	clock.Advance(time.Minute)
+	time.Sleep(10 * time.Millisecond)
+	fsm.Locker().Lock()
+	defer fsm.Locker().Unlock()
+	if fsm.Current() != "paused" {
+		t.Fatalf("expected paused, got %s", fsm.Current())
+	}
+}
+
+func TestAfterDelayLocker(t *testing.T) {
+	clock := newFakeClock()
+	fsm := newDelayMachine(clock)
+	lock := fsm.Locker()
+	lock.Lock()
+	fsm.Event("start")
+	lock.Unlock()
+
+	clock.Advance(time.Minute)
+	lock.Lock()
+	fsm.Event("answer")
+	lock.Unlock()
+
+	lock.Lock()
+	defer lock.Unlock()
+	if state := fsm.Current(); state != "done" && state != "expired" {
+		t.Fatalf("expected done or expired, got %s", state)
+	}
+}
+
+func TestDebounce(t *testing.T) {
+	clock := newFakeClock()
+	fsm := NewStateMachine(
+		"off",
+		Events{
+			{Name: "toggle", Src: []string{"off"}, Dst: "on"},
+			{Name: "toggle", Src: []string{"on"}, Dst: "off"},
+		},
+		Handlers{},
+	)
+	fsm.SetClock(clock)
+	fsm.Debounce("toggle", time.Second)
+
+	if err := fsm.Event("toggle"); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(500 * time.Millisecond)
+	err := fsm.Event("toggle")
+	if _, ok := err.(*DebouncedError); !ok {
+		t.Fatalf("expected DebouncedError, got %v", err)
+	}
+	if fsm.Current() != "on" {
+		t.Fatalf("expected on, got %s", fsm.Current())
+	}
+
+	clock.Advance(500 * time.Millisecond)
+	if err := fsm.Event("toggle"); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.Current() != "off" {
+		t.Fatalf("expected off, got %s", fsm.Current())
+	}
+}
+
+func TestDebounceRollback(t *testing.T) {
+	clock := newFakeClock()
+	fsm := NewStateMachine(
+		"a",
+		Events{
+			{Name: "go", Src: []string{"a"}, Dst: "b"},
+		},
+		Handlers{
+			"enter_b": func(e *Event) {
+				if len(e.Args) == 0 {
+					e.Cancel()
+				}
+			},
+		},
+		WithEnterRollback(),
+	)
+	fsm.SetClock(clock)
+	fsm.Debounce("go", time.Second)
+
+	fsm.Event("go")
+	if fsm.Current() != "a" {
+		t.Fatalf("expected the transition to be rolled back, got %s", fsm.Current())
+	}
+	if err := fsm.Event("go", "keep"); err != nil {
+		t.Fatalf("expected no debounce window after a rollback, got %v", err)
+	}
+	if fsm.Current() != "b" {
+		t.Fatalf("expected b, got %s", fsm.Current())
+	}
+}
@@ -0,0 +1,90 @@
+package statemachine
+
+import (
+	"errors"
+	"strings"
+)
+
+// maxSuggestionDistance is the largest edit distance between an unknown
+// event and a known one for DescribeError to suggest the known one.
+const maxSuggestionDistance = 2
+
+// DescribeError returns a message for err that adds the current state and
+// the events available in it, for the errors returned by Event. For an
+// UnknownEventError it also suggests the closest known event name, if one is
+// close enough to be a likely typo. Other errors are described by their
+// Error method alone, and a nil error by an empty string.
+func (machine *StateMachine) DescribeError(err error) string {
+	if err == nil {
+		return ""
+	}
+	var (
+		inTransition *InTransitionError
+		invalid      *InvalidEventError
+		unknown      *UnknownEventError
+		rejected     *GuardRejectedError
+	)
+	if !errors.As(err, &inTransition) && !errors.As(err, &invalid) &&
+		!errors.As(err, &unknown) && !errors.As(err, &rejected) {
+		return err.Error()
+	}
+
+	var b strings.Builder
+	b.WriteString(err.Error())
+	b.WriteString(" (current state ")
+	b.WriteString(machine.Current())
+	if events := machine.AvailableTransitions(); len(events) > 0 {
+		b.WriteString(", available events: ")
+		b.WriteString(strings.Join(events, ", "))
+	} else {
+		b.WriteString(", no available events")
+	}
+	b.WriteString(")")
+
+	if unknown != nil {
+		if suggestion := machine.closestEvent(unknown.Event); suggestion != "" {
+			b.WriteString("; did you mean ")
+			b.WriteString(suggestion)
+			b.WriteString("?")
+		}
+	}
+	return b.String()
+}
+
+// closestEvent returns the known event with the smallest edit distance to
+// name, or an empty string if none is within maxSuggestionDistance. Ties are
+// broken alphabetically.
+func (machine *StateMachine) closestEvent(name string) string {
+	best := ""
+	bestDistance := maxSuggestionDistance + 1
+	for event := range machine.allEvents {
+		d := levenshtein(name, event)
+		if d < bestDistance || (d == bestDistance && event < best) {
+			best = event
+			bestDistance = d
+		}
+	}
+	return best
+}
+
+// levenshtein returns the edit distance between a and b.
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
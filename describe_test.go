@@ -0,0 +1,63 @@
+package statemachine
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDescribeError(t *testing.T) {
+	fsm := NewStateMachine(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+			{Name: "close", Src: []string{"open"}, Dst: "closed"},
+			{Name: "lock", Src: []string{"closed"}, Dst: "locked"},
+		},
+		Handlers{},
+	)
+
+	err := fsm.Event("opne")
+	if _, ok := err.(*UnknownEventError); !ok {
+		t.Fatalf("expected an unknown event error, got %v", err)
+	}
+	expected := "event opne does not exist (current state closed, available events: lock, open); did you mean open?"
+	if msg := fsm.DescribeError(err); msg != expected {
+		t.Fatalf("expected %q, got %q", expected, msg)
+	}
+
+	err = fsm.Event("explode")
+	expected = "event explode does not exist (current state closed, available events: lock, open)"
+	if msg := fsm.DescribeError(err); msg != expected {
+		t.Fatalf("expected %q, got %q", expected, msg)
+	}
+
+	err = fsm.Event("close")
+	expected = "event close inappropriate in current state closed (current state closed, available events: lock, open)"
+	if msg := fsm.DescribeError(err); msg != expected {
+		t.Fatalf("expected %q, got %q", expected, msg)
+	}
+
+	if msg := fsm.DescribeError(fmt.Errorf("other")); msg != "other" {
+		t.Fatalf("expected other errors to be left alone, got %q", msg)
+	}
+	if msg := fsm.DescribeError(fsm.Event("open")); msg != "" {
+		t.Fatalf("expected an empty message for nil, got %q", msg)
+	}
+}
+
+func TestLevenshtein(t *testing.T) {
+	for _, c := range []struct {
+		a, b string
+		d    int
+	}{
+		{"", "", 0},
+		{"open", "", 4},
+		{"open", "open", 0},
+		{"opne", "open", 2},
+		{"kitten", "sitting", 3},
+	} {
+		if d := levenshtein(c.a, c.b); d != c.d {
+			t.Fatalf("levenshtein(%q, %q) = %d, want %d", c.a, c.b, d, c.d)
+		}
+	}
+}
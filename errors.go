@@ -0,0 +1,157 @@
+package statemachine
+
+import (
+	"strconv"
+)
+
+// InTransitionError is returned by Event when an asynchronous startState has
+// not been completed with Excute yet.
+type InTransitionError struct {
+	Event string
+}
+
+func (e *InTransitionError) Error() string {
+	return "event " + e.Event + " inappropriate because previous startState did not complete"
+}
+
+// InvalidEventError is returned by Event when the event is not defined for
+// the current state.
+type InvalidEventError struct {
+	Event string
+	State string
+}
+
+func (e *InvalidEventError) Error() string {
+	return "event " + e.Event + " inappropriate in current state " + e.State
+}
+
+// UnknownEventError is returned by Event when the event is not defined for
+// any state.
+type UnknownEventError struct {
+	Event string
+}
+
+func (e *UnknownEventError) Error() string {
+	return "event " + e.Event + " does not exist"
+}
+
+// GuardRejectedError is returned by Event when the guards of every
+// transition of the event from the current state reject it.
+type GuardRejectedError struct {
+	Event string
+	State string
+}
+
+func (e *GuardRejectedError) Error() string {
+	return "event " + e.Event + " rejected by guards in current state " + e.State
+}
+
+// NotInTransitionError is returned by Excute when there is no asynchronous
+// startState to complete.
+type NotInTransitionError struct{}
+
+func (e *NotInTransitionError) Error() string {
+	return "startState inappropriate because no state change in progress"
+}
+
+// ReentrantExecuteError is returned by Excute and ExecuteWith when they are
+// called from an enter_ or after_ handler while the startState is being
+// committed.
+type ReentrantExecuteError struct{}
+
+func (e *ReentrantExecuteError) Error() string {
+	return "startState inappropriate because the state change is being committed"
+}
+
+// InternalError is returned by Event on an internal bug and should never
+// occur.
+type InternalError struct{}
+
+func (e *InternalError) Error() string {
+	return "internal error on state startState"
+}
+
+// LateCancelError is returned by Event when an enter_ or after_ handler
+// cancelled an event whose transition had already been committed, so the
+// cancellation had no effect. See WithEnterRollback to undo the transition
+// instead.
+type LateCancelError struct {
+	Event string
+	State string
+	// Err is the error passed to CancelWithError, if any.
+	Err error
+}
+
+func (e *LateCancelError) Error() string {
+	msg := "event " + e.Event + " canceled after entering " + e.State
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *LateCancelError) Unwrap() error {
+	return e.Err
+}
+
+// DebouncedError is returned by Event when the event is fired again within
+// its Debounce window.
+type DebouncedError struct {
+	Event string
+}
+
+func (e *DebouncedError) Error() string {
+	return "event " + e.Event + " debounced"
+}
+
+// GuardViolationError is returned by Event when a guard tried to change the
+// event it was evaluating.
+type GuardViolationError struct {
+	// Event is the name of the event being guarded.
+	Event string
+	// Method is the first method the guard called, or "Err" if it set the
+	// error field.
+	Method string
+}
+
+func (e *GuardViolationError) Error() string {
+	if e.Method == "Err" {
+		return "guard for event " + e.Event + " must not set Err"
+	}
+	return "guard for event " + e.Event + " must not call " + e.Method
+}
+
+// StepError reports which step of a sequence of events failed.
+type StepError struct {
+	// Macro is the name of the macro that was fired, if any.
+	Macro string
+	// Step is the zero-based index of the failed step.
+	Step int
+	// Event is the name of the event fired in the failed step.
+	Event string
+	// Err is the error returned for the failed step.
+	Err error
+}
+
+func (e *StepError) Error() string {
+	msg := "step " + strconv.Itoa(e.Step) + " (" + e.Event + "): " + e.Err.Error()
+	if e.Macro != "" {
+		msg = "macro " + e.Macro + " " + msg
+	}
+	return msg
+}
+
+func (e *StepError) Unwrap() error {
+	return e.Err
+}
+
+// NoPathError is returned by PathTo when no sequence of events leads from the
+// current state to the target.
+type NoPathError struct {
+	From string
+	To   string
+}
+
+func (e *NoPathError) Error() string {
+	return "no path from " + e.From + " to " + e.To
+}
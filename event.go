@@ -1,5 +1,11 @@
 package statemachine
 
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+)
+
 type Event struct {
 	StateMachine *StateMachine
 	Name         string
@@ -11,10 +17,24 @@ type Event struct {
 	Err error
 	// Args is a optinal list of arguments passed to the callback.
 	Args []interface{}
+	// NamedArgs are the optional arguments passed to EventWith by name.
+	NamedArgs map[string]interface{}
 	// canceled is an internal flag set if the startState is canceled.
 	canceled bool
 	// async is an internal flag set if the startState should be asynchronous
 	async bool
+	// flags are the per-call feature flags passed to EventWithFlags.
+	flags map[string]bool
+	// desc is the transition selected for the event.
+	desc *EventDesc
+	// depth is the number of Goto calls that led to the event.
+	depth int
+	// guard is set while the event is being viewed by a guard.
+	guard *guardEvent
+	// done receives the result of an event fired with EventAsync, and
+	// detached is set once its startState has been handed to a goroutine.
+	done     chan error
+	detached bool
 }
 
 type Events []EventDesc
@@ -23,6 +43,57 @@ type EventDesc struct {
 	Name string
 	Src  []string
 	Dst  string
+	// SrcExcept is used instead of Src when Src is empty. The event can
+	// then occur in every known state except the listed ones. For a given
+	// state, transitions that list it in Src take precedence.
+	SrcExcept []string
+	// SrcPattern is used instead of Src when Src is empty. The event can
+	// then occur in every known state whose name matches the pattern,
+	// except those listed in SrcExcept. For a given state, transitions that
+	// list it in Src take precedence.
+	SrcPattern *regexp.Regexp
+	// DstFunc optionally computes the destination from the arguments of
+	// the event, overriding Dst. The state it returns must be known to the
+	// machine, for example as the Dst of another transition or through
+	// AddState. Dst may be left empty, in which case the transition is left
+	// out of exports and graph analyses.
+	DstFunc func(args []interface{}) string
+	// DstFromSrc optionally computes the destination from the state the
+	// event occurs in, overriding Dst, for example to go from "step_1" to
+	// "step_2" with a single transition declared with SrcPattern. Like for
+	// DstFunc the state it returns must be known to the machine. DstFunc
+	// takes precedence if both are set.
+	DstFromSrc func(src string) string
+	// Guard is an optional condition that must return true for the
+	// transition to be taken. It receives a read-only view of the event.
+	Guard func(*Event) bool
+	// Before is an optional function called for this transition only,
+	// right after the before_ handlers. It can adjust the arguments of the
+	// event, and returning an error cancels the event with that error and
+	// calls the error handlers.
+	Before func(*Event) error
+	// Action is an optional function called when this transition is
+	// committed, right after the state has changed and before the enter_
+	// handlers. Unlike handlers it belongs to this transition only, so
+	// transitions sharing an event name can have different actions.
+	Action func(*Event)
+	// Priority orders the guarded transitions that share a name and source.
+	// Higher priorities are tried first; equal ones in declaration order.
+	Priority int
+	// Weight is the relative chance of this transition being taken among
+	// the guardless transitions that share its name and source when the
+	// machine uses EnableWeighted. Zero counts as 1.
+	Weight float64
+	// Terminal marks Dst as an intended dead end, a state without
+	// outgoing transitions. With WithDeadEndCheck, NewCheckedStateMachine
+	// reports dead ends that no transition marks as Terminal, which are
+	// often typos.
+	Terminal bool
+	// Label is an optional human-readable name of the transition, used
+	// instead of the event name as the edge label in exports. Doc is an
+	// optional description. Neither has any effect on the machine.
+	Label string
+	Doc   string
 }
 
 // stateKey is a struct key used for storing the startState map.
@@ -35,9 +106,26 @@ type stateKey struct {
 }
 
 // Cancel can be called in before_<EVENT> or leave_<STATE> to cancel the
-// current startState before it happens.
+// current startState before it happens. Once the startState has been
+// committed it is too late: cancelling in enter_<STATE> or after_<EVENT>
+// makes Event return a LateCancelError, unless the machine was constructed
+// with WithEnterRollback.
 func (event *Event) Cancel() {
+	if event.readOnly("Cancel") {
+		return
+	}
+	event.canceled = true
+}
+
+// CancelWithError cancels the current startState like Cancel and makes Event
+// return err, so that callers can tell a blocked transition from a successful
+// one.
+func (event *Event) CancelWithError(err error) {
+	if event.readOnly("CancelWithError") {
+		return
+	}
 	event.canceled = true
+	event.Err = err
 }
 
 // Async can be called in leave_<STATE> to do an asynchronous state startState.
@@ -45,5 +133,109 @@ func (event *Event) Cancel() {
 // call to Excute is made. This will comlete the startState and possibly
 // call the other handlers.
 func (event *Event) Async() {
+	if event.readOnly("Async") {
+		return
+	}
 	event.async = true
 }
+
+// Redirect can be called in before_<EVENT> or leave_<STATE> to change the
+// state the startState ends in. The enter_ handlers of the new destination are
+// called instead of those of the original one. Redirecting to a state that is
+// not known to the machine cancels the startState with an error.
+func (event *Event) Redirect(dst string) {
+	if event.readOnly("Redirect") {
+		return
+	}
+	if !event.StateMachine.allStates[dst] {
+		event.CancelWithError(fmt.Errorf("event %s redirected to unknown state %s", event.Name, dst))
+		return
+	}
+	event.Dst = dst
+}
+
+// SetStateData can be called in enter_<STATE> handlers to store v for the
+// state being entered. It can be read with StateMachine.StateData while the
+// machine stays in the state, including by the leave_ handlers of the next
+// startState. It is discarded once that startState passes the leave_ phase,
+// but kept if the startState is cancelled.
+func (event *Event) SetStateData(v interface{}) {
+	if event.readOnly("SetStateData") {
+		return
+	}
+	event.StateMachine.setStateData(v)
+}
+
+// Goto can be called in enter_<STATE> or after_<EVENT> to fire nextEvent with
+// args once the current startState has been committed, for states that route
+// onward on their own. The follow-up event keeps the NamedArgs of the current
+// one. If it fails, its error is returned by the Event or Excute call that
+// committed the current startState.
+//
+// Chains of Goto calls are limited by WithMaxChainDepth.
+func (event *Event) Goto(nextEvent string, args ...interface{}) {
+	if event.readOnly("Goto") {
+		return
+	}
+	event.StateMachine.enqueue(&Event{Name: nextEvent, Args: args, NamedArgs: event.NamedArgs, depth: event.depth + 1})
+}
+
+// AppendArg adds v to the arguments of the event, so that a value computed in
+// a before_ or leave_ handler reaches the handlers of later phases. Every
+// phase sees the same event, including the enter_ and after_ handlers of an
+// asynchronous startState completed later with Excute.
+func (event *Event) AppendArg(v interface{}) {
+	if event.readOnly("AppendArg") {
+		return
+	}
+	event.Args = append(event.Args, v)
+}
+
+// Arg returns the argument at index i, or an error if there is no such
+// argument.
+func (event *Event) Arg(i int) (interface{}, error) {
+	if i < 0 || i >= len(event.Args) {
+		return nil, fmt.Errorf("arg %d out of range, event %s has %d args", i, event.Name, len(event.Args))
+	}
+	return event.Args[i], nil
+}
+
+// NamedArg returns the argument passed to EventWith under key, and whether
+// there is one.
+func (event *Event) NamedArg(key string) (interface{}, bool) {
+	v, ok := event.NamedArgs[key]
+	return v, ok
+}
+
+// Arg returns the argument of e at index i as a T, or an error if there is no
+// such argument or it is not a T.
+func Arg[T any](e *Event, i int) (T, error) {
+	var zero T
+	arg, err := e.Arg(i)
+	if err != nil {
+		return zero, err
+	}
+	v, ok := arg.(T)
+	if !ok {
+		return zero, fmt.Errorf("arg %d is %T, want %s", i, arg, reflect.TypeOf(&zero).Elem())
+	}
+	return v, nil
+}
+
+// Flag returns the value of the named feature flag passed to
+// EventWithFlags. Unset flags are false.
+func (event *Event) Flag(name string) bool {
+	return event.flags[name]
+}
+
+// readOnly returns true if the event is being viewed by a guard, in which case
+// the call to method is recorded as a violation.
+func (event *Event) readOnly(method string) bool {
+	if event.guard == nil {
+		return false
+	}
+	if event.guard.violation == "" {
+		event.guard.violation = method
+	}
+	return true
+}
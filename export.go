@@ -0,0 +1,260 @@
+package statemachine
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"sort"
+	"strings"
+)
+
+// edge is a single drawable transition between two states.
+type edge struct {
+	src   string
+	event string
+	dst   string
+}
+
+// edges returns every declared transition sorted by source, event and
+// destination.
+func (machine *StateMachine) edges() []edge {
+	var edges []edge
+	for key, candidates := range machine.states {
+		for _, desc := range candidates {
+			if desc.Dst != "" {
+				edges = append(edges, edge{key.src, key.event, desc.Dst})
+			}
+		}
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].src != edges[j].src {
+			return edges[i].src < edges[j].src
+		}
+		if edges[i].event != edges[j].event {
+			return edges[i].event < edges[j].event
+		}
+		return edges[i].dst < edges[j].dst
+	})
+	return edges
+}
+
+// label returns the Label of the transition drawn as e, or the event name if
+// it has none.
+func (machine *StateMachine) label(e edge) string {
+	for _, desc := range machine.states[stateKey{e.event, e.src}] {
+		if desc.Dst == e.dst && desc.Label != "" {
+			return desc.Label
+		}
+	}
+	return e.event
+}
+
+// Labels are escaped for each diagram language, since a Label is free text
+// that may contain the quotes, separators or line breaks of the language.
+var (
+	dotLabel      = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	mermaidLabel  = strings.NewReplacer(":", "#58;", "\n", "<br>")
+	plantUMLLabel = strings.NewReplacer(`\`, `\\`, ":", "<U+003A>", "\n", `\n`)
+)
+
+// ExportOption adjusts the output of the diagram exporters.
+type ExportOption func(*exportConfig)
+
+// exportConfig holds the settings made by ExportOptions.
+type exportConfig struct {
+	highlightPending bool
+}
+
+// HighlightPending marks the edge of an asynchronous startState that is
+// waiting for Excute, so that a paused machine shows which transition is
+// mid-flight. DOT output draws the edge dashed and Mermaid output adds
+// "(pending)" to its label.
+func HighlightPending() ExportOption {
+	return func(config *exportConfig) {
+		config.highlightPending = true
+	}
+}
+
+// newExportConfig applies opts to a default configuration.
+func newExportConfig(opts []ExportOption) exportConfig {
+	var config exportConfig
+	for _, opt := range opts {
+		opt(&config)
+	}
+	return config
+}
+
+// isPending returns true if e is the edge of the pending startState and the
+// configuration asks for it to be highlighted.
+func (machine *StateMachine) isPending(e edge, config exportConfig) bool {
+	if !config.highlightPending {
+		return false
+	}
+	machine.stateMu.RLock()
+	defer machine.stateMu.RUnlock()
+	pending := machine.pending
+	return machine.startState != nil && pending != nil &&
+		e.src == pending.Src && e.event == pending.Name && e.dst == pending.Dst
+}
+
+// isolatedStates returns the sorted declared states that have no transitions
+// and are not the initial state.
+func (machine *StateMachine) isolatedStates() []string {
+	connected := map[string]bool{machine.initial: true}
+	for key, candidates := range machine.states {
+		connected[key.src] = true
+		for _, desc := range candidates {
+			if desc.Dst != "" {
+				connected[desc.Dst] = true
+			}
+		}
+	}
+
+	var states []string
+	for state := range machine.allStates {
+		if !connected[state] {
+			states = append(states, state)
+		}
+	}
+	sort.Strings(states)
+	return states
+}
+
+// Format is a diagram language supported by Visualize.
+type Format int
+
+const (
+	// FormatDOT is the Graphviz DOT language, as returned by ToDOT.
+	FormatDOT Format = iota
+
+	// FormatMermaid is a Mermaid state diagram, as returned by ToMermaid.
+	FormatMermaid
+
+	// FormatPlantUML is a PlantUML state diagram, as returned by
+	// ToPlantUML.
+	FormatPlantUML
+)
+
+// Visualize writes the transition graph to w in format. Unlike the To
+// methods it streams the diagram instead of building it in memory, which
+// matters for large machines. It returns the first error of w, or an error if
+// format is unknown.
+func (machine *StateMachine) Visualize(w io.Writer, format Format, opts ...ExportOption) error {
+	b := &exportWriter{w: w}
+	config := newExportConfig(opts)
+	switch format {
+	case FormatDOT:
+		machine.writeDOT(b, config)
+	case FormatMermaid:
+		machine.writeMermaid(b, config)
+	case FormatPlantUML:
+		machine.writePlantUML(b)
+	default:
+		return fmt.Errorf("unknown format %d", format)
+	}
+	return b.err
+}
+
+// exportWriter writes to w until the first error, which it keeps.
+type exportWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (b *exportWriter) WriteString(s string) {
+	if b.err == nil {
+		_, b.err = io.WriteString(b.w, s)
+	}
+}
+
+// ToDOT returns the transition graph in the Graphviz DOT language.
+func (machine *StateMachine) ToDOT(opts ...ExportOption) string {
+	var b strings.Builder
+	machine.writeDOT(&exportWriter{w: &b}, newExportConfig(opts))
+	return b.String()
+}
+
+func (machine *StateMachine) writeDOT(b *exportWriter, config exportConfig) {
+	b.WriteString("digraph fsm {\n")
+	for _, e := range machine.edges() {
+		b.WriteString("    \"" + e.src + "\" -> \"" + e.dst + "\" [ label = \"" + dotLabel.Replace(machine.label(e)) + "\"")
+		if machine.isPending(e, config) {
+			b.WriteString(", style = \"dashed\", color = \"blue\"")
+		}
+		b.WriteString(" ];\n")
+	}
+	for _, state := range machine.isolatedStates() {
+		b.WriteString("    \"" + state + "\";\n")
+	}
+	b.WriteString("}\n")
+}
+
+// ToMermaid returns the transition graph as a Mermaid state diagram.
+func (machine *StateMachine) ToMermaid(opts ...ExportOption) string {
+	var b strings.Builder
+	machine.writeMermaid(&exportWriter{w: &b}, newExportConfig(opts))
+	return b.String()
+}
+
+func (machine *StateMachine) writeMermaid(b *exportWriter, config exportConfig) {
+	b.WriteString("stateDiagram-v2\n")
+	b.WriteString("    [*] --> " + machine.initial + "\n")
+	for _, e := range machine.edges() {
+		b.WriteString("    " + e.src + " --> " + e.dst + ": " + mermaidLabel.Replace(machine.label(e)))
+		if machine.isPending(e, config) {
+			b.WriteString(" (pending)")
+		}
+		b.WriteString("\n")
+	}
+	for _, state := range machine.isolatedStates() {
+		b.WriteString("    " + state + "\n")
+	}
+}
+
+// ToPlantUML returns the transition graph as a PlantUML state diagram. Every
+// state is declared, and a transition with several sources is drawn as one
+// arrow per source.
+func (machine *StateMachine) ToPlantUML() string {
+	var b strings.Builder
+	machine.writePlantUML(&exportWriter{w: &b})
+	return b.String()
+}
+
+func (machine *StateMachine) writePlantUML(b *exportWriter) {
+	b.WriteString("@startuml\n")
+	b.WriteString("[*] --> " + machine.initial + "\n")
+	for _, state := range machine.States() {
+		b.WriteString("state \"" + state + "\" as " + state + "\n")
+	}
+	for _, e := range machine.edges() {
+		b.WriteString(e.src + " --> " + e.dst + " : " + plantUMLLabel.Replace(machine.label(e)) + "\n")
+	}
+	b.WriteString("@enduml\n")
+}
+
+// ToHTML returns a self-contained HTML page rendering the Mermaid diagram of
+// the machine with the current state and any pending transition highlighted,
+// followed by the events that are available in the current state.
+func (machine *StateMachine) ToHTML() string {
+	inspection := machine.Inspect()
+	var diagram strings.Builder
+	machine.writeMermaid(&exportWriter{w: &diagram}, exportConfig{highlightPending: true})
+	diagram.WriteString("    classDef current fill:#f96,stroke:#333,stroke-width:2px\n")
+	diagram.WriteString("    class " + inspection.Current + " current\n")
+
+	var b strings.Builder
+	b.WriteString("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n")
+	b.WriteString("<title>State machine</title>\n")
+	b.WriteString("<script src=\"https://cdn.jsdelivr.net/npm/mermaid/dist/mermaid.min.js\"></script>\n")
+	b.WriteString("</head>\n<body>\n")
+	b.WriteString("<pre class=\"mermaid\">\n" + html.EscapeString(diagram.String()) + "</pre>\n")
+	b.WriteString("<p>Current state: <strong>" + html.EscapeString(inspection.Current) + "</strong></p>\n")
+	b.WriteString("<ul>\n")
+	for _, event := range inspection.AvailableTransitions {
+		b.WriteString("<li>" + html.EscapeString(event) + "</li>\n")
+	}
+	b.WriteString("</ul>\n")
+	b.WriteString("<script>mermaid.initialize({startOnLoad: true});</script>\n")
+	b.WriteString("</body>\n</html>\n")
+	return b.String()
+}
@@ -0,0 +1,252 @@
+package statemachine
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestToMermaid(t *testing.T) {
+	fsm := NewStateMachine(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+			{Name: "close", Src: []string{"open"}, Dst: "closed"},
+		},
+		Handlers{},
+	)
+	expected := `stateDiagram-v2
+    [*] --> closed
+    closed --> open: open
+    open --> closed: close
+`
+	if got := fsm.ToMermaid(); got != expected {
+		t.Fatalf("expected\n%s\ngot\n%s", expected, got)
+	}
+}
+
+func TestExportLabel(t *testing.T) {
+	fsm := NewStateMachine(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open", Label: "open the door", Doc: "Opens an unlocked door."},
+			{Name: "close", Src: []string{"open"}, Dst: "closed"},
+		},
+		Handlers{},
+	)
+	expected := `stateDiagram-v2
+    [*] --> closed
+    closed --> open: open the door
+    open --> closed: close
+`
+	if got := fsm.ToMermaid(); got != expected {
+		t.Fatalf("expected\n%s\ngot\n%s", expected, got)
+	}
+	if !strings.Contains(fsm.ToDOT(), `[ label = "open the door" ]`) {
+		t.Fatalf("expected the label in\n%s", fsm.ToDOT())
+	}
+	if !strings.Contains(fsm.ToPlantUML(), "closed --> open : open the door") {
+		t.Fatalf("expected the label in\n%s", fsm.ToPlantUML())
+	}
+
+	for _, desc := range fsm.Transitions() {
+		if desc.Name == "open" && (desc.Label != "open the door" || desc.Doc != "Opens an unlocked door.") {
+			t.Fatalf("expected the label and doc in %+v", desc)
+		}
+	}
+}
+
+func TestExportLabelEscaped(t *testing.T) {
+	fsm := NewStateMachine(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open", Label: "say \"hi\": C:\\door\nnow"},
+		},
+		Handlers{},
+	)
+	if got := fsm.ToDOT(); !strings.Contains(got, `[ label = "say \"hi\": C:\\door\nnow" ]`) {
+		t.Fatalf("expected the escaped label in\n%s", got)
+	}
+	if got := fsm.ToMermaid(); !strings.Contains(got, `closed --> open: say "hi"#58; C#58;\door<br>now`) {
+		t.Fatalf("expected the escaped label in\n%s", got)
+	}
+	if got := fsm.ToPlantUML(); !strings.Contains(got, `closed --> open : say "hi"<U+003A> C<U+003A>\\door\nnow`) {
+		t.Fatalf("expected the escaped label in\n%s", got)
+	}
+}
+
+func TestToHTML(t *testing.T) {
+	fsm := NewStateMachine(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+			{Name: "close", Src: []string{"open"}, Dst: "closed"},
+		},
+		Handlers{},
+	)
+	fsm.Event("open")
+	page := fsm.ToHTML()
+	for _, s := range []string{
+		`<script src="https://cdn.jsdelivr.net/npm/mermaid/dist/mermaid.min.js"></script>`,
+		"class open current",
+		"Current state: <strong>open</strong>",
+		"<li>close</li>",
+	} {
+		if !strings.Contains(page, s) {
+			t.Fatalf("expected page to contain %q:\n%s", s, page)
+		}
+	}
+}
+
+func TestToDOT(t *testing.T) {
+	fsm := NewStateMachine(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+			{Name: "close", Src: []string{"open"}, Dst: "closed"},
+		},
+		Handlers{},
+	)
+	expected := `digraph fsm {
+    "closed" -> "open" [ label = "open" ];
+    "open" -> "closed" [ label = "close" ];
+}
+`
+	if got := fsm.ToDOT(); got != expected {
+		t.Fatalf("expected\n%s\ngot\n%s", expected, got)
+	}
+}
+
+func TestToPlantUML(t *testing.T) {
+	fsm := NewStateMachine(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+			{Name: "close", Src: []string{"open"}, Dst: "closed"},
+			{Name: "lock", Src: []string{"closed", "open"}, Dst: "locked"},
+		},
+		Handlers{},
+	)
+	expected := `@startuml
+[*] --> closed
+state "closed" as closed
+state "locked" as locked
+state "open" as open
+closed --> locked : lock
+closed --> open : open
+open --> closed : close
+open --> locked : lock
+@enduml
+`
+	if got := fsm.ToPlantUML(); got != expected {
+		t.Fatalf("expected\n%s\ngot\n%s", expected, got)
+	}
+}
+
+func TestAddStateExport(t *testing.T) {
+	fsm := NewStateMachine(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+			{Name: "close", Src: []string{"open"}, Dst: "closed"},
+		},
+		Handlers{},
+	)
+	fsm.AddState("archived")
+
+	if dot := fsm.ToDOT(); !strings.Contains(dot, "    \"archived\";\n") {
+		t.Fatalf("expected archived in DOT output:\n%s", dot)
+	}
+	if mermaid := fsm.ToMermaid(); !strings.Contains(mermaid, "    archived\n") {
+		t.Fatalf("expected archived in Mermaid output:\n%s", mermaid)
+	}
+	if strings.Contains(fsm.ToDOT(), "    \"open\";\n") {
+		t.Fatal("expected connected states to be drawn only through their edges")
+	}
+}
+
+func TestHighlightPending(t *testing.T) {
+	fsm := NewStateMachine(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+			{Name: "close", Src: []string{"open"}, Dst: "closed"},
+		},
+		Handlers{
+			"leave_closed": func(e *Event) {
+				e.Async()
+			},
+		},
+	)
+	if strings.Contains(fsm.ToDOT(HighlightPending()), "dashed") {
+		t.Fatal("expected no highlighted edge without a pending transition")
+	}
+
+	fsm.Event("open")
+	dot := fsm.ToDOT(HighlightPending())
+	if !strings.Contains(dot, `"closed" -> "open" [ label = "open", style = "dashed", color = "blue" ];`) {
+		t.Fatalf("expected the pending edge to be dashed:\n%s", dot)
+	}
+	if strings.Contains(fsm.ToDOT(), "dashed") {
+		t.Fatal("expected highlighting to be opt-in")
+	}
+	mermaid := fsm.ToMermaid(HighlightPending())
+	if !strings.Contains(mermaid, "closed --> open: open (pending)\n") || strings.Contains(mermaid, "close (pending)") {
+		t.Fatalf("expected only the pending edge to be marked:\n%s", mermaid)
+	}
+
+	fsm.Excute()
+	if strings.Contains(fsm.ToDOT(HighlightPending()), "dashed") {
+		t.Fatal("expected no highlighted edge after Excute")
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("write failed")
+}
+
+func TestVisualize(t *testing.T) {
+	fsm := NewStateMachine(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+			{Name: "close", Src: []string{"open"}, Dst: "closed"},
+		},
+		Handlers{},
+	)
+
+	for _, test := range []struct {
+		format   Format
+		expected string
+	}{
+		{FormatDOT, fsm.ToDOT()},
+		{FormatMermaid, fsm.ToMermaid()},
+		{FormatPlantUML, `@startuml
+[*] --> closed
+state "closed" as closed
+state "open" as open
+closed --> open : open
+open --> closed : close
+@enduml
+`},
+	} {
+		var b bytes.Buffer
+		if err := fsm.Visualize(&b, test.format); err != nil {
+			t.Fatal(err)
+		}
+		if b.String() != test.expected {
+			t.Fatalf("format %d: expected\n%s\ngot\n%s", test.format, test.expected, b.String())
+		}
+	}
+
+	var b bytes.Buffer
+	if err := fsm.Visualize(&b, Format(42)); err == nil || err.Error() != "unknown format 42" {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := fsm.Visualize(failingWriter{}, FormatDOT); err == nil || err.Error() != "write failed" {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
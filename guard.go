@@ -0,0 +1,30 @@
+package statemachine
+
+// guardEvent is the read-only view of an Event handed to guards. Guards must
+// not influence the transition other than through their return value, so calls
+// to methods such as Cancel or Async are recorded as a violation instead of
+// taking effect.
+type guardEvent struct {
+	Event
+	origErr   error
+	violation string
+}
+
+// newGuardEvent returns a guard view of event for the candidate dst.
+func newGuardEvent(event *Event, dst string) *guardEvent {
+	guard := &guardEvent{Event: *event, origErr: event.Err}
+	guard.Event.Dst = dst
+	guard.Event.guard = guard
+	return guard
+}
+
+// err returns a GuardViolationError if the guard tried to change the event.
+func (guard *guardEvent) err() error {
+	if guard.violation == "" && guard.Event.Err != guard.origErr {
+		guard.violation = "Err"
+	}
+	if guard.violation == "" {
+		return nil
+	}
+	return &GuardViolationError{guard.Name, guard.violation}
+}
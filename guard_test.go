@@ -0,0 +1,271 @@
+package statemachine
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestGuard(t *testing.T) {
+	fsm := NewStateMachine(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "fast", Guard: func(e *Event) bool {
+				return len(e.Args) > 0 && e.Args[0] == "fast"
+			}},
+			{Name: "run", Src: []string{"start"}, Dst: "slow"},
+		},
+		Handlers{},
+	)
+	if err := fsm.Event("run", "fast"); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.Current() != "fast" {
+		t.Fatalf("expected fast, got %s", fsm.Current())
+	}
+}
+
+func TestGuardFallthrough(t *testing.T) {
+	fsm := NewStateMachine(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "fast", Guard: func(e *Event) bool {
+				return false
+			}},
+			{Name: "run", Src: []string{"start"}, Dst: "slow"},
+		},
+		Handlers{},
+	)
+	if err := fsm.Event("run"); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.Current() != "slow" {
+		t.Fatalf("expected slow, got %s", fsm.Current())
+	}
+}
+
+func TestGuardRejected(t *testing.T) {
+	fsm := NewStateMachine(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end", Guard: func(e *Event) bool {
+				return false
+			}},
+		},
+		Handlers{},
+	)
+	err := fsm.Event("run")
+	if err == nil || err.Error() != "event run rejected by guards in current state start" {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if fsm.Current() != "start" {
+		t.FailNow()
+	}
+}
+
+func TestGuardViolation(t *testing.T) {
+	for _, method := range []string{"Cancel", "Async", "Err"} {
+		method := method
+		fsm := NewStateMachine(
+			"start",
+			Events{
+				{Name: "run", Src: []string{"start"}, Dst: "end", Guard: func(e *Event) bool {
+					switch method {
+					case "Cancel":
+						e.Cancel()
+					case "Async":
+						e.Async()
+					case "Err":
+						e.Err = fmt.Errorf("error")
+					}
+					return true
+				}},
+			},
+			Handlers{},
+		)
+		err := fsm.Event("run")
+		var violation *GuardViolationError
+		if !errors.As(err, &violation) || violation.Method != method {
+			t.Fatalf("%s: expected guard violation, got %v", method, err)
+		}
+		if fsm.Current() != "start" {
+			t.Fatalf("%s: expected start, got %s", method, fsm.Current())
+		}
+	}
+}
+
+func TestGuardFlag(t *testing.T) {
+	newMachine := func() *StateMachine {
+		return NewStateMachine(
+			"start",
+			Events{
+				{Name: "run", Src: []string{"start"}, Dst: "beta", Guard: func(e *Event) bool {
+					return e.Flag("beta")
+				}},
+				{Name: "run", Src: []string{"start"}, Dst: "stable"},
+			},
+			Handlers{},
+		)
+	}
+
+	fsm := newMachine()
+	if err := fsm.EventWithFlags(map[string]bool{"beta": true}, "run"); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.Current() != "beta" {
+		t.Fatalf("expected beta, got %s", fsm.Current())
+	}
+
+	fsm = newMachine()
+	if err := fsm.EventWithFlags(map[string]bool{"beta": false}, "run"); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.Current() != "stable" {
+		t.Fatalf("expected stable, got %s", fsm.Current())
+	}
+
+	fsm = newMachine()
+	if err := fsm.Event("run"); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.Current() != "stable" {
+		t.Fatalf("expected stable, got %s", fsm.Current())
+	}
+}
+
+func TestCanGuarded(t *testing.T) {
+	fsm := NewStateMachine(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end", Guard: func(e *Event) bool {
+				return len(e.Args) > 0 && e.Args[0] == "ready"
+			}},
+		},
+		Handlers{},
+	)
+	if !fsm.Can("run") {
+		t.Fatal("expected Can to ignore guards")
+	}
+	if fsm.CanGuarded("run") {
+		t.Fatal("expected the guard to reject run")
+	}
+	if !fsm.CanGuarded("run", "ready") {
+		t.Fatal("expected the guard to accept run")
+	}
+	if fsm.CanGuarded("walk") {
+		t.Fatal("expected an unknown event to be rejected")
+	}
+}
+
+func TestGuardPriority(t *testing.T) {
+	always := func(e *Event) bool {
+		return true
+	}
+	fsm := NewStateMachine(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "slow", Guard: always},
+			{Name: "run", Src: []string{"start"}, Dst: "fast", Guard: always, Priority: 1},
+			{Name: "run", Src: []string{"start"}, Dst: "medium", Guard: always, Priority: 1},
+		},
+		Handlers{},
+	)
+	if err := fsm.Event("run"); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.Current() != "fast" {
+		t.Fatalf("expected fast, got %s", fsm.Current())
+	}
+}
+
+func TestGuardPriorityFallthrough(t *testing.T) {
+	fsm := NewStateMachine(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "slow"},
+			{Name: "run", Src: []string{"start"}, Dst: "fast", Priority: 1, Guard: func(e *Event) bool {
+				return len(e.Args) > 0 && e.Args[0] == "fast"
+			}},
+		},
+		Handlers{},
+	)
+	if err := fsm.Event("run"); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.Current() != "slow" {
+		t.Fatalf("expected slow, got %s", fsm.Current())
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	var calls int
+	fsm := NewStateMachine(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end", Guard: func(e *Event) bool {
+				return len(e.Args) > 0 && e.Args[0] == "ready"
+			}},
+		},
+		Handlers{
+			"before_run": func(e *Event) {
+				calls++
+			},
+			"enter_end": func(e *Event) {
+				calls++
+			},
+		},
+	)
+
+	dst, err := fsm.DryRun("run", "ready")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dst != "end" {
+		t.Fatalf("expected end, got %s", dst)
+	}
+
+	_, err = fsm.DryRun("run")
+	if _, ok := err.(*GuardRejectedError); !ok {
+		t.Fatalf("expected GuardRejectedError, got %v", err)
+	}
+	_, err = fsm.DryRun("walk")
+	if _, ok := err.(*UnknownEventError); !ok {
+		t.Fatalf("expected UnknownEventError, got %v", err)
+	}
+
+	if fsm.Current() != "start" || calls != 0 {
+		t.Fatalf("expected no side effects, got state %s and %d handler calls", fsm.Current(), calls)
+	}
+}
+
+func TestMetadataGuard(t *testing.T) {
+	fsm := NewStateMachine(
+		"draft",
+		Events{
+			{Name: "publish", Src: []string{"draft"}, Dst: "published", Guard: func(e *Event) bool {
+				role, _ := e.StateMachine.GetMetadata("role")
+				return role == "admin"
+			}},
+		},
+		Handlers{},
+	)
+
+	fsm.SetMetadata("role", "editor")
+	if fsm.CanGuarded("publish") {
+		t.Fatal("expected publish to be blocked for an editor")
+	}
+	if _, ok := fsm.Event("publish").(*GuardRejectedError); !ok || fsm.Current() != "draft" {
+		t.Fatalf("expected the guard to reject publish, got %s", fsm.Current())
+	}
+
+	fsm.SetMetadata("role", "admin")
+	if !fsm.CanGuarded("publish") {
+		t.Fatal("expected publish to be allowed for an admin")
+	}
+	if err := fsm.Event("publish"); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.Current() != "published" {
+		t.Fatalf("expected published, got %s", fsm.Current())
+	}
+}
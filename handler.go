@@ -1,5 +1,13 @@
 package statemachine
 
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode"
+)
+
 type Handlers map[string]Handler
 
 type Handler func(*Event)
@@ -12,8 +20,38 @@ const (
 	leaveState
 	enterState
 	afterEvent
+	onTransition
+	enterFinal
+	rollbackState
+	onError
+	abortState
 )
 
+// String returns the name of the phase the handler type runs in.
+func (handlerType handlerType) String() string {
+	switch handlerType {
+	case beforeEvent:
+		return "before"
+	case leaveState:
+		return "leave"
+	case enterState:
+		return "enter"
+	case afterEvent:
+		return "after"
+	case onTransition:
+		return "transition"
+	case enterFinal:
+		return "final"
+	case rollbackState:
+		return "rollback"
+	case onError:
+		return "error"
+	case abortState:
+		return "abort"
+	}
+	return "none"
+}
+
 // handlerKey is a struct key used for keeping the handlers mapped to a target.
 type handlerKey struct {
 	// target is either the name of a state or an event depending on which
@@ -22,3 +60,265 @@ type handlerKey struct {
 	// handlerType is the situation when the callback will be run.
 	handlerType handlerType
 }
+
+// String returns the handler name that resolves to the key, using the long
+// form for specific handlers.
+func (key handlerKey) String() string {
+	switch key.handlerType {
+	case beforeEvent:
+		if key.target == "" {
+			return "before_event"
+		}
+		return "before_" + key.target
+	case leaveState:
+		if key.target == "" {
+			return "leave_state"
+		}
+		return "leave_" + key.target
+	case enterState:
+		if key.target == "" {
+			return "enter_state"
+		}
+		return "enter_" + key.target
+	case afterEvent:
+		if key.target == "" {
+			return "after_event"
+		}
+		return "after_" + key.target
+	case onTransition:
+		return "transition"
+	case enterFinal:
+		return "final"
+	case rollbackState:
+		return "rollback_" + key.target
+	case onError:
+		return "error"
+	case abortState:
+		return "abort_" + key.target
+	}
+	return key.target
+}
+
+// resolveHandler parses a handler name as described in NewStateMachine and
+// returns the key the handler is stored under. The second return value is
+// false if the name does not refer to a known event or state.
+func resolveHandler(handlerName string, allEvents, allStates map[string]bool) (handlerKey, bool) {
+	var target string
+	var handlerType handlerType
+
+	// The name of a known state or event is its shorthand, even if it is
+	// also the name of a hook.
+	shorthand := allStates[handlerName] || allEvents[handlerName]
+
+	switch {
+	case strings.HasPrefix(handlerName, "before_"):
+		target = strings.TrimPrefix(handlerName, "before_")
+		if target == "event" {
+			target = ""
+			handlerType = beforeEvent
+		} else if _, ok := allEvents[target]; ok {
+			handlerType = beforeEvent
+		}
+	case strings.HasPrefix(handlerName, "leave_"):
+		target = strings.TrimPrefix(handlerName, "leave_")
+		if target == "state" {
+			target = ""
+			handlerType = leaveState
+		} else if _, ok := allStates[target]; ok {
+			handlerType = leaveState
+		}
+	case strings.HasPrefix(handlerName, "enter_"):
+		target = strings.TrimPrefix(handlerName, "enter_")
+		if target == "state" {
+			target = ""
+			handlerType = enterState
+		} else if _, ok := allStates[target]; ok {
+			handlerType = enterState
+		}
+	case strings.HasPrefix(handlerName, "after_"):
+		target = strings.TrimPrefix(handlerName, "after_")
+		if target == "event" {
+			target = ""
+			handlerType = afterEvent
+		} else if _, ok := allEvents[target]; ok {
+			handlerType = afterEvent
+		}
+	case strings.HasPrefix(handlerName, "rollback_"):
+		target = strings.TrimPrefix(handlerName, "rollback_")
+		if _, ok := allStates[target]; ok {
+			handlerType = rollbackState
+		}
+	case strings.HasPrefix(handlerName, "abort_"):
+		target = strings.TrimPrefix(handlerName, "abort_")
+		if _, ok := allStates[target]; ok {
+			handlerType = abortState
+		}
+	case handlerName == "transition" && !shorthand:
+		handlerType = onTransition
+	case handlerName == "final" && !shorthand:
+		handlerType = enterFinal
+	case handlerName == "error" && !shorthand:
+		handlerType = onError
+	default:
+		target = handlerName
+		if _, ok := allStates[target]; ok {
+			handlerType = enterState
+		} else if _, ok := allEvents[target]; ok {
+			handlerType = afterEvent
+		}
+	}
+
+	return handlerKey{target, handlerType}, handlerType != noHandler
+}
+
+// AddHandler registers handler for the hook name, which is parsed like the
+// keys of Handlers in NewStateMachine. Unlike the Handlers map it allows
+// several handlers for one hook; they run in the order they were added,
+// after any handler passed to NewStateMachine.
+//
+// AddHandler returns an error if handler is nil or if hook does not refer to
+// a known event or state.
+func (machine *StateMachine) AddHandler(hook string, handler Handler) error {
+	return machine.AddHandlerWithPriority(hook, 0, handler)
+}
+
+// AddHandlerWithPriority is like AddHandler but orders the handlers of hook by
+// prio, lowest first. Handlers with the same priority run in the order they
+// were added; those passed to NewStateMachine or added with AddHandler have
+// priority 0. Priorities only order the handlers of one hook, such as
+// after_event; the order of the hooks themselves is unchanged.
+func (machine *StateMachine) AddHandlerWithPriority(hook string, prio int, handler Handler) error {
+	if handler == nil {
+		return fmt.Errorf("handler %s is nil", hook)
+	}
+	key, ok := resolveHandler(hook, machine.allEvents, machine.allStates)
+	if !ok {
+		return fmt.Errorf("handler %s does not refer to a known event or state", hook)
+	}
+	machine.addHandler(key, prio, handler)
+	return nil
+}
+
+// addHandler inserts handler into the handlers of key after every handler
+// with a priority up to prio.
+func (machine *StateMachine) addHandler(key handlerKey, prio int, handler Handler) {
+	handlers := machine.handlers[key]
+	prios := make([]int, len(handlers), len(handlers)+1)
+	copy(prios, machine.priorities[key])
+
+	i := len(handlers)
+	for i > 0 && prios[i-1] > prio {
+		i--
+	}
+	handlers = append(handlers, nil)
+	copy(handlers[i+1:], handlers[i:])
+	handlers[i] = handler
+	prios = append(prios, 0)
+	copy(prios[i+1:], prios[i:])
+	prios[i] = prio
+
+	machine.handlers[key] = handlers
+	machine.priorities[key] = prios
+}
+
+// HasHandler returns true if a handler is registered for the hook name, which
+// is resolved exactly like the keys of Handlers in NewStateMachine. A name
+// that does not refer to a known event or state, such as one with a typo,
+// has no handler.
+func (machine *StateMachine) HasHandler(hook string) bool {
+	key, ok := resolveHandler(hook, machine.allEvents, machine.allStates)
+	return ok && len(machine.handlers[key]) > 0
+}
+
+// OnEnterAny adds handler as the enter_<STATE> handler of each of states. It
+// returns the joined errors of the states that are not known; the others are
+// registered regardless.
+func (machine *StateMachine) OnEnterAny(states []string, handler Handler) error {
+	var errs []error
+	for _, state := range states {
+		errs = append(errs, machine.AddHandler("enter_"+state, handler))
+	}
+	return errors.Join(errs...)
+}
+
+// OnEventAny adds handler as the after_<EVENT> handler of each of events. It
+// returns the joined errors of the events that are not known; the others are
+// registered regardless.
+func (machine *StateMachine) OnEventAny(events []string, handler Handler) error {
+	var errs []error
+	for _, event := range events {
+		errs = append(errs, machine.AddHandler("after_"+event, handler))
+	}
+	return errors.Join(errs...)
+}
+
+// OnEnterFunc adds handler as a generic enter_state handler that only runs
+// for the states match returns true for, for example every state whose name
+// starts with "error_". match is called with the destination of the event
+// each time a transition is committed. A nil match or handler is ignored.
+func (machine *StateMachine) OnEnterFunc(match func(state string) bool, handler Handler) {
+	if match == nil || handler == nil {
+		return
+	}
+	machine.addHandler(handlerKey{"", enterState}, 0, func(e *Event) {
+		if match(e.Dst) {
+			handler(e)
+		}
+	})
+}
+
+// BindHandlers registers the methods of obj that are named after hooks as
+// handlers, like AddHandler. A method is bound if its name is one of Before,
+// Leave, Enter, After, Rollback or Abort followed by the name of an event or
+// state in CamelCase, where every upper-case letter starts a new word of the
+// snake_case name. For example BeforeRun is bound to before_run, EnterInReview
+// to enter_in_review and AfterEvent to after_event. Other methods are ignored.
+//
+// BindHandlers returns the joined errors of the methods named like a hook
+// that do not have the signature func(*Event) or do not refer to a known
+// event or state; the other methods are bound regardless.
+func (machine *StateMachine) BindHandlers(obj interface{}) error {
+	if obj == nil {
+		return fmt.Errorf("cannot bind handlers of nil")
+	}
+	v := reflect.ValueOf(obj)
+	handlerType := reflect.TypeOf(func(*Event) {})
+
+	var errs []error
+	for i := 0; i < v.NumMethod(); i++ {
+		name := v.Type().Method(i).Name
+		hook, ok := hookName(name)
+		if !ok {
+			continue
+		}
+		method := v.Method(i)
+		if method.Type() != handlerType {
+			errs = append(errs, fmt.Errorf("method %s bound to %s is %s, want func(*Event)", name, hook, method.Type()))
+			continue
+		}
+		errs = append(errs, machine.AddHandler(hook, method.Interface().(func(*Event))))
+	}
+	return errors.Join(errs...)
+}
+
+// hookName returns the handler name of a method named as described in
+// BindHandlers, and false if the method name does not start with a hook.
+func hookName(method string) (string, bool) {
+	for _, prefix := range []string{"Before", "Leave", "Enter", "After", "Rollback", "Abort"} {
+		target := strings.TrimPrefix(method, prefix)
+		if target == method || target == "" || !unicode.IsUpper([]rune(target)[0]) {
+			continue
+		}
+
+		var b strings.Builder
+		b.WriteString(strings.ToLower(prefix))
+		for _, r := range target {
+			if unicode.IsUpper(r) {
+				b.WriteByte('_')
+			}
+			b.WriteRune(unicode.ToLower(r))
+		}
+		return b.String(), true
+	}
+	return "", false
+}
@@ -0,0 +1,214 @@
+package statemachine
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestAddHandler(t *testing.T) {
+	var calls []string
+	fsm := NewStateMachine(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+		},
+		Handlers{
+			"after_run": func(e *Event) {
+				calls = append(calls, "first")
+			},
+		},
+	)
+	if err := fsm.AddHandler("run", func(e *Event) {
+		calls = append(calls, "second")
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := fsm.AddHandler("after_rnu", func(e *Event) {}); err == nil {
+		t.Fatal("expected an error for an unknown event")
+	}
+	if err := fsm.AddHandler("after_run", nil); err == nil {
+		t.Fatal("expected an error for a nil handler")
+	}
+
+	fsm.Event("run")
+	if fmt.Sprint(calls) != "[first second]" {
+		t.Fatalf("expected [first second], got %v", calls)
+	}
+}
+
+func TestOnEnterAny(t *testing.T) {
+	var entered []string
+	fsm := NewStateMachine(
+		"idle",
+		Events{
+			{Name: "start", Src: []string{"idle"}, Dst: "running"},
+			{Name: "pause", Src: []string{"running"}, Dst: "paused"},
+			{Name: "stop", Src: []string{"running", "paused"}, Dst: "stopped"},
+		},
+		Handlers{},
+	)
+	err := fsm.OnEnterAny([]string{"running", "paused", "stopped"}, func(e *Event) {
+		entered = append(entered, e.Dst)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fsm.OnEventAny([]string{"start", "stop"}, func(e *Event) {
+		entered = append(entered, "after "+e.Name)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fsm.Event("start")
+	fsm.Event("pause")
+	fsm.Event("stop")
+	expected := []string{"running", "after start", "paused", "stopped", "after stop"}
+	if fmt.Sprint(entered) != fmt.Sprint(expected) {
+		t.Fatalf("expected %v, got %v", expected, entered)
+	}
+
+	if err := fsm.OnEnterAny([]string{"idle", "runing"}, func(e *Event) {}); err == nil {
+		t.Fatal("expected an error for an unknown state")
+	}
+}
+
+func TestHasHandler(t *testing.T) {
+	noop := func(e *Event) {}
+	fsm := NewStateMachine(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+		},
+		Handlers{
+			"enter_open":  noop,
+			"enter_opne":  noop,
+			"before_open": noop,
+		},
+	)
+
+	for hook, expected := range map[string]bool{
+		"enter_open":  true,
+		"open":        true,
+		"before_open": true,
+		"enter_opne":  false,
+		"after_open":  false,
+		"leave_state": false,
+	} {
+		if got := fsm.HasHandler(hook); got != expected {
+			t.Fatalf("%s: expected %v, got %v", hook, expected, got)
+		}
+	}
+}
+
+func TestOnEnterFunc(t *testing.T) {
+	var entered []string
+	fsm := NewStateMachine(
+		"idle",
+		Events{
+			{Name: "start", Src: []string{"idle"}, Dst: "running"},
+			{Name: "timeout", Src: []string{"running"}, Dst: "error_timeout"},
+			{Name: "retry", Src: []string{"error_timeout"}, Dst: "running"},
+			{Name: "crash", Src: []string{"running"}, Dst: "error_crash"},
+		},
+		Handlers{},
+	)
+	fsm.OnEnterFunc(func(state string) bool {
+		return strings.HasPrefix(state, "error_")
+	}, func(e *Event) {
+		entered = append(entered, e.Dst)
+	})
+
+	for _, event := range []string{"start", "timeout", "retry", "crash"} {
+		if err := fsm.Event(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if fmt.Sprint(entered) != "[error_timeout error_crash]" {
+		t.Fatalf("expected [error_timeout error_crash], got %v", entered)
+	}
+}
+
+func TestAddHandlerWithPriority(t *testing.T) {
+	var calls []string
+	fsm := NewStateMachine(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+		},
+		Handlers{},
+	)
+	fsm.AddHandlerWithPriority("after_event", 10, record(&calls, "late"))
+	fsm.AddHandlerWithPriority("after_event", -5, record(&calls, "early"))
+	fsm.AddHandler("after_event", record(&calls, "default"))
+	fsm.AddHandlerWithPriority("after_event", 10, record(&calls, "late again"))
+
+	fsm.Event("run")
+	expected := []string{"early", "default", "late", "late again"}
+	if fmt.Sprint(calls) != fmt.Sprint(expected) {
+		t.Fatalf("expected %v, got %v", expected, calls)
+	}
+}
+
+type boundHandlers struct {
+	calls []string
+}
+
+func (h *boundHandlers) BeforeRun(e *Event) {
+	h.calls = append(h.calls, "before_run")
+}
+
+func (h *boundHandlers) EnterInReview(e *Event) {
+	h.calls = append(h.calls, "enter_in_review")
+}
+
+func (h *boundHandlers) AfterEvent(e *Event) {
+	h.calls = append(h.calls, "after_event")
+}
+
+func (h *boundHandlers) Reset() {
+	h.calls = nil
+}
+
+func TestBindHandlers(t *testing.T) {
+	fsm := NewStateMachine(
+		"draft",
+		Events{
+			{Name: "run", Src: []string{"draft"}, Dst: "in_review"},
+		},
+		Handlers{},
+	)
+	h := &boundHandlers{}
+	if err := fsm.BindHandlers(h); err != nil {
+		t.Fatal(err)
+	}
+
+	fsm.Event("run")
+	expected := []string{"before_run", "enter_in_review", "after_event"}
+	if fmt.Sprint(h.calls) != fmt.Sprint(expected) {
+		t.Fatalf("expected %v, got %v", expected, h.calls)
+	}
+}
+
+type misnamedHandlers struct{}
+
+func (misnamedHandlers) AfterRnu(e *Event) {}
+
+func (misnamedHandlers) EnterDraft() {}
+
+func TestBindHandlersErrors(t *testing.T) {
+	fsm := NewStateMachine(
+		"draft",
+		Events{
+			{Name: "run", Src: []string{"draft"}, Dst: "in_review"},
+		},
+		Handlers{},
+	)
+	err := fsm.BindHandlers(misnamedHandlers{})
+	expected := "handler after_rnu does not refer to a known event or state\n" +
+		"method EnterDraft bound to enter_draft is func(), want func(*Event)"
+	if err == nil || err.Error() != expected {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
@@ -0,0 +1,97 @@
+package statemachine
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// historyLimit is the number of committed transitions kept by the machine.
+const historyLimit = 1024
+
+// Transition is a record of a committed state change.
+type Transition struct {
+	// Event is the name of the event that caused the transition.
+	Event string
+	// Src is the state before the transition.
+	Src string
+	// Dst is the state after the transition.
+	Dst string
+	// Time is when the transition was committed, according to the clock of
+	// the machine.
+	Time time.Time
+}
+
+// record appends a committed transition to the history, dropping the oldest
+// entry once historyLimit is reached.
+func (machine *StateMachine) record(t Transition) {
+	if len(machine.history) == historyLimit {
+		copy(machine.history, machine.history[1:])
+		machine.history = machine.history[:historyLimit-1]
+		machine.historyStart++
+	}
+	machine.history = append(machine.history, t)
+}
+
+// TransitionCount returns the number of transitions committed since the
+// machine was constructed. Cancelled transitions, self-transitions and
+// asynchronous ones still waiting for Excute are not counted.
+func (machine *StateMachine) TransitionCount() uint64 {
+	machine.stateMu.RLock()
+	defer machine.stateMu.RUnlock()
+	return machine.transitionCount
+}
+
+// EnterCount returns how many committed transitions have entered state since
+// the machine was constructed, for example to detect loops in a workflow.
+// Being in the initial state at construction does not count.
+func (machine *StateMachine) EnterCount(state string) int {
+	return machine.enterCount[state]
+}
+
+// ChangesSince returns the transitions committed since token was handed out
+// together with a new token to pass to the next call.
+//
+// Tokens are opaque. An empty or unrecognised token returns all transitions
+// still kept by the machine, which is at most the most recent 1024.
+func (machine *StateMachine) ChangesSince(token string) (changes []Transition, newToken string) {
+	end := machine.historyStart + uint64(len(machine.history))
+
+	start := machine.historyStart
+	if seq, err := strconv.ParseUint(token, 10, 64); err == nil && seq > start {
+		start = seq
+	}
+	if start < end {
+		changes = make([]Transition, end-start)
+		copy(changes, machine.history[start-machine.historyStart:])
+	}
+
+	return changes, strconv.FormatUint(end, 10)
+}
+
+// Replay fires the events of history in order, checking that every step
+// starts in the recorded source state and ends in the recorded destination.
+// It is meant for a fresh machine with the same definition as the one the
+// history was taken from, which is in its initial state, to reconstruct the
+// state deterministically. Handlers run as they do for Event. Recorded
+// transitions carry no arguments, so the events are fired without any.
+//
+// The first step that fails or disagrees with the history stops the replay
+// and is returned as a *StepError. The machine is left where that step put
+// it.
+func (machine *StateMachine) Replay(history []Transition) error {
+	for i, step := range history {
+		if src := machine.Current(); src != step.Src {
+			return &StepError{Step: i, Event: step.Event,
+				Err: fmt.Errorf("recorded from %s, but the machine is in %s", step.Src, src)}
+		}
+		if err := machine.Event(step.Event); err != nil {
+			return &StepError{Step: i, Event: step.Event, Err: err}
+		}
+		if dst := machine.Current(); dst != step.Dst {
+			return &StepError{Step: i, Event: step.Event,
+				Err: fmt.Errorf("recorded to %s, but the machine went to %s", step.Dst, dst)}
+		}
+	}
+	return nil
+}
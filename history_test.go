@@ -0,0 +1,167 @@
+package statemachine
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestChangesSince(t *testing.T) {
+	fsm := NewStateMachine(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+			{Name: "close", Src: []string{"open"}, Dst: "closed"},
+		},
+		Handlers{},
+	)
+	clock := newFakeClock()
+	fsm.SetClock(clock)
+
+	fsm.Event("open")
+	clock.Advance(time.Second)
+	fsm.Event("close")
+	changes, token := fsm.ChangesSince("")
+	expected := []Transition{
+		{"open", "closed", "open", clock.Now().Add(-time.Second)},
+		{"close", "open", "closed", clock.Now()},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Fatalf("expected %v, got %v", expected, changes)
+	}
+
+	changes, token = fsm.ChangesSince(token)
+	if len(changes) != 0 {
+		t.Fatalf("expected no changes, got %v", changes)
+	}
+
+	fsm.Event("open")
+	changes, _ = fsm.ChangesSince(token)
+	expected = []Transition{
+		{"open", "closed", "open", clock.Now()},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Fatalf("expected %v, got %v", expected, changes)
+	}
+}
+
+func TestChangesSinceLimit(t *testing.T) {
+	fsm := NewStateMachine(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+			{Name: "close", Src: []string{"open"}, Dst: "closed"},
+		},
+		Handlers{},
+	)
+
+	for i := 0; i < historyLimit; i++ {
+		fsm.Event("open")
+		fsm.Event("close")
+	}
+	changes, _ := fsm.ChangesSince("")
+	if len(changes) != historyLimit {
+		t.Fatalf("expected %d changes, got %d", historyLimit, len(changes))
+	}
+	if changes[0].Event != "open" || changes[len(changes)-1].Event != "close" {
+		t.Fatalf("unexpected window %v ... %v", changes[0], changes[len(changes)-1])
+	}
+}
+
+func TestReplay(t *testing.T) {
+	newMachine := func() *StateMachine {
+		return NewStateMachine(
+			"draft",
+			Events{
+				{Name: "submit", Src: []string{"draft"}, Dst: "review"},
+				{Name: "reject", Src: []string{"review"}, Dst: "draft"},
+				{Name: "approve", Src: []string{"review"}, Dst: "published"},
+			},
+			Handlers{},
+		)
+	}
+
+	fsm := newMachine()
+	for _, event := range []string{"submit", "reject", "submit", "approve"} {
+		if err := fsm.Event(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	history, _ := fsm.ChangesSince("")
+
+	replayed := newMachine()
+	if err := replayed.Replay(history); err != nil {
+		t.Fatal(err)
+	}
+	if replayed.Current() != fsm.Current() {
+		t.Fatalf("expected %s, got %s", fsm.Current(), replayed.Current())
+	}
+
+	replayed = newMachine()
+	history[1].Dst = "published"
+	err := replayed.Replay(history)
+	if err == nil || err.Error() != "step 1 (reject): recorded to published, but the machine went to draft" {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestTransitionCount(t *testing.T) {
+	fsm := NewStateMachine(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+			{Name: "close", Src: []string{"open"}, Dst: "closed"},
+		},
+		Handlers{
+			"leave_open": func(e *Event) {
+				if len(e.Args) == 0 {
+					return
+				}
+				switch e.Args[0] {
+				case "cancel":
+					e.Cancel()
+				case "async":
+					e.Async()
+				}
+			},
+		},
+	)
+
+	fsm.Event("open")
+	fsm.Event("close", "cancel")
+	fsm.Event("close")
+	fsm.Event("open")
+	fsm.Event("close", "async")
+	if count := fsm.TransitionCount(); count != 3 {
+		t.Fatalf("expected 3 transitions, got %d", count)
+	}
+
+	fsm.Excute()
+	if count := fsm.TransitionCount(); count != 4 {
+		t.Fatalf("expected 4 transitions, got %d", count)
+	}
+}
+
+func TestEnterCount(t *testing.T) {
+	fsm := NewStateMachine(
+		"green",
+		Events{
+			{Name: "warn", Src: []string{"green"}, Dst: "yellow"},
+			{Name: "stop", Src: []string{"yellow"}, Dst: "red"},
+			{Name: "ready", Src: []string{"red"}, Dst: "yellow"},
+			{Name: "go", Src: []string{"yellow"}, Dst: "green"},
+		},
+		Handlers{},
+	)
+
+	for _, event := range []string{"warn", "stop", "ready", "go"} {
+		if err := fsm.Event(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for state, expected := range map[string]int{"green": 1, "yellow": 2, "red": 1, "blue": 0} {
+		if count := fsm.EnterCount(state); count != expected {
+			t.Fatalf("%s: expected %d, got %d", state, expected, count)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package statemachine
+
+// Inspection is a consistent view of a machine for diagnostics.
+type Inspection struct {
+	// Current is the current state.
+	Current string
+	// Pending is true while a startState is in progress, from its leave_
+	// phase until it has been committed. For an asynchronous startState
+	// this includes the time it waits for Excute.
+	Pending bool
+	// AvailableTransitions are the sorted events defined for Current.
+	AvailableTransitions []string
+	// TransitionCount is the number of committed transitions, see
+	// StateMachine.TransitionCount.
+	TransitionCount uint64
+}
+
+// Inspect returns the current state, whether a startState is pending, the
+// available transitions and the transition count, all taken at the same
+// moment. Unlike separate calls to the getters it is safe to call while
+// another goroutine fires events and never mixes two states of the machine.
+func (machine *StateMachine) Inspect() Inspection {
+	machine.stateMu.RLock()
+	defer machine.stateMu.RUnlock()
+	return Inspection{
+		Current:              machine.current,
+		Pending:              machine.startState != nil,
+		AvailableTransitions: machine.AvailableTransitionsFrom(machine.current),
+		TransitionCount:      machine.transitionCount,
+	}
+}
+
+// PendingEvent returns the name of the event whose startState is in
+// progress, such as an asynchronous one waiting for Excute, and true, or
+// false if none is. Like Inspect it is safe to call from another goroutine.
+func (machine *StateMachine) PendingEvent() (name string, ok bool) {
+	machine.stateMu.RLock()
+	defer machine.stateMu.RUnlock()
+	if machine.startState == nil || machine.pending == nil {
+		return "", false
+	}
+	return machine.pending.Name, true
+}
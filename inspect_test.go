@@ -0,0 +1,132 @@
+package statemachine
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestInspect(t *testing.T) {
+	fsm := NewStateMachine(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+			{Name: "close", Src: []string{"open"}, Dst: "closed"},
+		},
+		Handlers{},
+	)
+
+	const toggles = 1000
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < toggles; i++ {
+			fsm.Event("open")
+			fsm.Event("close")
+		}
+	}()
+
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < toggles; j++ {
+				inspection := fsm.Inspect()
+				open := inspection.TransitionCount%2 == 1
+				if open != (inspection.Current == "open") {
+					t.Errorf("inconsistent inspection %+v", inspection)
+					return
+				}
+				expected := "[open]"
+				if open {
+					expected = "[close]"
+				}
+				if fmt.Sprint(inspection.AvailableTransitions) != expected {
+					t.Errorf("inconsistent inspection %+v", inspection)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if inspection := fsm.Inspect(); inspection.Current != "closed" || inspection.TransitionCount != 2*toggles {
+		t.Fatalf("unexpected final inspection %+v", inspection)
+	}
+}
+
+func TestPendingEvent(t *testing.T) {
+	fsm := NewStateMachine(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+		},
+		Handlers{
+			"leave_start": func(e *Event) {
+				e.Async()
+			},
+		},
+	)
+	if _, ok := fsm.PendingEvent(); ok {
+		t.Fatal("expected no pending event")
+	}
+
+	fsm.Event("run")
+	if name, ok := fsm.PendingEvent(); !ok || name != "run" {
+		t.Fatalf("expected run to be pending, got %q, %v", name, ok)
+	}
+	if err := fsm.Excute(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fsm.PendingEvent(); ok {
+		t.Fatal("expected no pending event after Excute")
+	}
+}
+
+func TestConcurrentReads(t *testing.T) {
+	fsm := NewStateMachine(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+			{Name: "close", Src: []string{"open"}, Dst: "closed"},
+		},
+		Handlers{
+			"leave_closed": func(e *Event) {
+				e.Async()
+			},
+			"enter_open": func(e *Event) {
+				e.SetStateData("session")
+			},
+		},
+	)
+
+	const toggles = 200
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < toggles; i++ {
+			fsm.Event("open")
+			fsm.Excute()
+			fsm.Event("close")
+		}
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < toggles; i++ {
+			state, pendingTo, pending := fsm.CurrentWithPending()
+			if pending && (state != "closed" || pendingTo != "open") {
+				t.Errorf("inconsistent pending startState from %s to %s", state, pendingTo)
+				return
+			}
+			if fsm.StateData() == nil && fsm.String() == "" && fsm.ToHTML() == "" {
+				t.Error("expected a summary and a page")
+				return
+			}
+		}
+	}()
+	wg.Wait()
+}
@@ -0,0 +1,121 @@
+package statemachine
+
+import (
+	"sort"
+)
+
+// Transitions returns the declared transitions, with the sources that share an
+// event and destination grouped into a single EventDesc. The result is sorted
+// by event and then destination, and each Src is sorted. The other fields,
+// such as Guard, Label and Doc, are those of the first transition of the
+// group.
+func (machine *StateMachine) Transitions() []EventDesc {
+	type group struct {
+		event string
+		dst   string
+	}
+
+	var descs []EventDesc
+	index := make(map[group]int)
+	for key, candidates := range machine.states {
+		for _, desc := range candidates {
+			g := group{key.event, desc.Dst}
+			i, ok := index[g]
+			if !ok {
+				i = len(descs)
+				index[g] = i
+				grouped := *desc
+				grouped.Src = nil
+				descs = append(descs, grouped)
+			}
+			descs[i].Src = append(descs[i].Src, key.src)
+		}
+	}
+
+	for i := range descs {
+		sort.Strings(descs[i].Src)
+	}
+	sort.SliceStable(descs, func(i, j int) bool {
+		if descs[i].Name != descs[j].Name {
+			return descs[i].Name < descs[j].Name
+		}
+		return descs[i].Dst < descs[j].Dst
+	})
+	return descs
+}
+
+// DiffTransitions compares the declared transitions of machine and other,
+// ignoring their current states. Added holds the transitions of other that
+// machine does not have and removed those of machine that other does not
+// have, grouped and sorted like Transitions. A transition counts as the same
+// in both machines if it has the same event, source and destination.
+func (machine *StateMachine) DiffTransitions(other *StateMachine) (added, removed []EventDesc) {
+	mine, theirs := machine.Transitions(), other.Transitions()
+	return missingTransitions(theirs, mine), missingTransitions(mine, theirs)
+}
+
+// missingTransitions returns the transitions of descs that are not in from,
+// keeping only the missing sources of each.
+func missingTransitions(descs, from []EventDesc) []EventDesc {
+	known := make(map[edge]bool)
+	for _, desc := range from {
+		for _, src := range desc.Src {
+			known[edge{src, desc.Name, desc.Dst}] = true
+		}
+	}
+
+	var missing []EventDesc
+	for _, desc := range descs {
+		var srcs []string
+		for _, src := range desc.Src {
+			if !known[edge{src, desc.Name, desc.Dst}] {
+				srcs = append(srcs, src)
+			}
+		}
+		if len(srcs) > 0 {
+			desc.Src = srcs
+			missing = append(missing, desc)
+		}
+	}
+	return missing
+}
+
+// DestinationsOf returns the destination of event from every source it is
+// defined for, keyed by source. An event may lead to a different state from
+// each of its sources, for example a "reset" event declared once per source.
+// As for DestinationFrom, guards are ignored and the first declared
+// transition of a source is taken.
+func (machine *StateMachine) DestinationsOf(event string) map[string]string {
+	event = machine.canonical(event)
+	destinations := make(map[string]string)
+	for key, candidates := range machine.states {
+		if key.event == event {
+			destinations[key.src] = candidates[0].Dst
+		}
+	}
+	return destinations
+}
+
+// States returns the sorted names of all known states: the initial state,
+// those used by transitions and those declared with AddState.
+func (machine *StateMachine) States() []string {
+	states := []string{machine.initial}
+	for state := range machine.allStates {
+		if state != machine.initial {
+			states = append(states, state)
+		}
+	}
+	sort.Strings(states)
+	return states
+}
+
+// Events returns the sorted names of all declared events. Aliases and macros
+// are not included.
+func (machine *StateMachine) Events() []string {
+	var events []string
+	for event := range machine.allEvents {
+		events = append(events, event)
+	}
+	sort.Strings(events)
+	return events
+}
@@ -0,0 +1,147 @@
+package statemachine
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTransitions(t *testing.T) {
+	events := Events{
+		{Name: "first", Src: []string{"one"}, Dst: "two"},
+		{Name: "second", Src: []string{"two"}, Dst: "three"},
+		{Name: "reset", Src: []string{"one", "two", "three"}, Dst: "one"},
+	}
+	fsm := NewStateMachine("one", events, Handlers{})
+
+	transitions := fsm.Transitions()
+	if len(transitions) != len(events) {
+		t.Fatalf("expected %d transitions, got %v", len(events), transitions)
+	}
+	for _, expected := range events {
+		found := false
+		for _, got := range transitions {
+			if got.Name == expected.Name && got.Dst == expected.Dst {
+				found = true
+				if !sameStrings(got.Src, expected.Src) {
+					t.Fatalf("%s: expected sources %v, got %v", expected.Name, expected.Src, got.Src)
+				}
+			}
+		}
+		if !found {
+			t.Fatalf("missing transition %s", expected.Name)
+		}
+	}
+	if transitions[0].Name != "first" || transitions[1].Name != "reset" || transitions[2].Name != "second" {
+		t.Fatalf("expected transitions sorted by event, got %v", transitions)
+	}
+}
+
+// sameStrings returns true if a and b hold the same strings in any order.
+func sameStrings(a, b []string) bool {
+	count := make(map[string]int)
+	for _, s := range a {
+		count[s]++
+	}
+	for _, s := range b {
+		count[s]--
+	}
+	for _, n := range count {
+		if n != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func TestDiffTransitions(t *testing.T) {
+	v1 := NewStateMachine(
+		"draft",
+		Events{
+			{Name: "submit", Src: []string{"draft"}, Dst: "review"},
+			{Name: "approve", Src: []string{"review"}, Dst: "published"},
+			{Name: "reject", Src: []string{"review"}, Dst: "draft"},
+		},
+		Handlers{},
+	)
+	v2 := NewStateMachine(
+		"review",
+		Events{
+			{Name: "submit", Src: []string{"draft"}, Dst: "review"},
+			{Name: "approve", Src: []string{"review"}, Dst: "published"},
+			{Name: "archive", Src: []string{"published"}, Dst: "archived"},
+		},
+		Handlers{},
+	)
+
+	added, removed := v1.DiffTransitions(v2)
+	if len(added) != 1 || added[0].Name != "archive" || added[0].Dst != "archived" || !sameStrings(added[0].Src, []string{"published"}) {
+		t.Fatalf("unexpected added transitions %v", added)
+	}
+	if len(removed) != 1 || removed[0].Name != "reject" || removed[0].Dst != "draft" || !sameStrings(removed[0].Src, []string{"review"}) {
+		t.Fatalf("unexpected removed transitions %v", removed)
+	}
+
+	added, removed = v1.DiffTransitions(v1)
+	if len(added) != 0 || len(removed) != 0 {
+		t.Fatalf("expected no differences, got %v and %v", added, removed)
+	}
+}
+
+func TestDestinationsOf(t *testing.T) {
+	fsm := NewStateMachine(
+		"start",
+		Events{
+			{Name: "first", Src: []string{"start"}, Dst: "one"},
+			{Name: "second", Src: []string{"start"}, Dst: "two"},
+			{Name: "reset", Src: []string{"one"}, Dst: "reset_one"},
+			{Name: "reset", Src: []string{"two"}, Dst: "reset_two"},
+			{Name: "reset", Src: []string{"reset_one", "reset_two"}, Dst: "start"},
+		},
+		Handlers{},
+	)
+
+	expected := map[string]string{
+		"one":       "reset_one",
+		"two":       "reset_two",
+		"reset_one": "start",
+		"reset_two": "start",
+	}
+	if got := fsm.DestinationsOf("reset"); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+	if got := fsm.DestinationsOf("walk"); len(got) != 0 {
+		t.Fatalf("expected no destinations, got %v", got)
+	}
+
+	fsm.Event("second")
+	fsm.Event("reset")
+	if fsm.Current() != "reset_two" {
+		t.Fatalf("expected reset_two, got %s", fsm.Current())
+	}
+	fsm.Event("reset")
+	if fsm.Current() != "start" {
+		t.Fatalf("expected start, got %s", fsm.Current())
+	}
+}
+
+func TestStatesAndEvents(t *testing.T) {
+	fsm := NewStateMachine(
+		"green",
+		Events{
+			{Name: "warn", Src: []string{"green"}, Dst: "yellow"},
+			{Name: "panic", Src: []string{"yellow"}, Dst: "red"},
+			{Name: "panic", Src: []string{"green"}, Dst: "red"},
+			{Name: "calm", Src: []string{"red"}, Dst: "yellow"},
+			{Name: "clear", Src: []string{"yellow"}, Dst: "green"},
+		},
+		Handlers{},
+	)
+	fsm.Alias("stop", "panic")
+
+	if states := fsm.States(); !reflect.DeepEqual(states, []string{"green", "red", "yellow"}) {
+		t.Fatalf("expected [green red yellow], got %v", states)
+	}
+	if events := fsm.Events(); !reflect.DeepEqual(events, []string{"calm", "clear", "panic", "warn"}) {
+		t.Fatalf("expected [calm clear panic warn], got %v", events)
+	}
+}
@@ -0,0 +1,174 @@
+package statemachine
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// definition is the JSON form of a machine written by DefinitionJSON.
+type definition struct {
+	Initial     string           `json:"initial"`
+	States      []string         `json:"states,omitempty"`
+	Transitions []jsonTransition `json:"transitions"`
+}
+
+// jsonTransition is the JSON form of an EventDesc. Functions such as guards
+// and actions cannot be represented and are left out.
+type jsonTransition struct {
+	Name     string   `json:"name"`
+	Src      []string `json:"src"`
+	Dst      string   `json:"dst"`
+	Priority int      `json:"priority,omitempty"`
+	Weight   float64  `json:"weight,omitempty"`
+	Terminal bool     `json:"terminal,omitempty"`
+	Label    string   `json:"label,omitempty"`
+	Doc      string   `json:"doc,omitempty"`
+}
+
+// DefinitionJSON returns the definition of the machine as JSON: the initial
+// state, the states declared with AddState that no transition uses and the
+// transitions. Transitions that share every field but their source are
+// written once with all their sources, sorted by event and destination.
+// Handlers and the current state are not included. Functions cannot be
+// written either, so DefinitionJSON returns an error for a transition with a
+// Guard, Before, Action, DstFunc or DstFromSrc rather than writing a machine
+// that behaves differently.
+func (machine *StateMachine) DefinitionJSON() ([]byte, error) {
+	type group struct {
+		name, dst, label, doc string
+		priority              int
+		weight                float64
+		terminal              bool
+	}
+
+	def := definition{
+		Initial:     machine.initial,
+		States:      machine.isolatedStates(),
+		Transitions: []jsonTransition{},
+	}
+	index := make(map[group]int)
+	for _, key := range machine.sortedKeys() {
+		for _, desc := range machine.states[key] {
+			if function := unwritable(desc); function != "" {
+				return nil, fmt.Errorf("transition %s from %s has a %s that cannot be written as JSON", key.event, key.src, function)
+			}
+			g := group{key.event, desc.Dst, desc.Label, desc.Doc, desc.Priority, desc.Weight, desc.Terminal}
+			i, ok := index[g]
+			if !ok {
+				i = len(def.Transitions)
+				index[g] = i
+				def.Transitions = append(def.Transitions, jsonTransition{
+					Name:     key.event,
+					Dst:      desc.Dst,
+					Priority: desc.Priority,
+					Weight:   desc.Weight,
+					Terminal: desc.Terminal,
+					Label:    desc.Label,
+					Doc:      desc.Doc,
+				})
+			}
+			def.Transitions[i].Src = append(def.Transitions[i].Src, key.src)
+		}
+	}
+	sort.SliceStable(def.Transitions, func(i, j int) bool {
+		if def.Transitions[i].Name != def.Transitions[j].Name {
+			return def.Transitions[i].Name < def.Transitions[j].Name
+		}
+		return def.Transitions[i].Dst < def.Transitions[j].Dst
+	})
+	return json.Marshal(def)
+}
+
+// unwritable returns a description of the function of desc that cannot be
+// written as JSON, or an empty string if it has none.
+func unwritable(desc *EventDesc) string {
+	switch {
+	case desc.DstFunc != nil || desc.DstFromSrc != nil:
+		return "computed destination"
+	case desc.Guard != nil:
+		return "guard"
+	case desc.Before != nil:
+		return "Before function"
+	case desc.Action != nil:
+		return "action"
+	}
+	return ""
+}
+
+// graph is the JSON form of a machine written by ToGraphJSON.
+type graph struct {
+	Nodes []graphNode `json:"nodes"`
+	Links []graphLink `json:"links"`
+}
+
+// graphNode is a state in the output of ToGraphJSON.
+type graphNode struct {
+	ID        string `json:"id"`
+	IsCurrent bool   `json:"isCurrent"`
+	IsFinal   bool   `json:"isFinal"`
+}
+
+// graphLink is a transition in the output of ToGraphJSON.
+type graphLink struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+	Label  string `json:"label"`
+	Event  string `json:"event"`
+}
+
+// ToGraphJSON returns the machine as a graph for front-end libraries such as
+// D3 or vis.js, in the form {"nodes": [...], "links": [...]}. Every state is
+// a node with an id and the isCurrent and isFinal flags, final states being
+// those returned by FinalStates. Every transition from a source is a link
+// from the id of the source to that of the destination, with the event and
+// the label drawn by the diagram exporters. Unlike DefinitionJSON the output
+// is meant for rendering and cannot be loaded back.
+func (machine *StateMachine) ToGraphJSON() ([]byte, error) {
+	final := make(map[string]bool)
+	for _, state := range machine.FinalStates() {
+		final[state] = true
+	}
+
+	g := graph{Nodes: []graphNode{}, Links: []graphLink{}}
+	current := machine.Current()
+	for _, state := range machine.States() {
+		g.Nodes = append(g.Nodes, graphNode{ID: state, IsCurrent: state == current, IsFinal: final[state]})
+	}
+	for _, e := range machine.edges() {
+		g.Links = append(g.Links, graphLink{Source: e.src, Target: e.dst, Label: machine.label(e), Event: e.event})
+	}
+	return json.Marshal(g)
+}
+
+// NewStateMachineFromJSON constructs a StateMachine from a definition written
+// by DefinitionJSON, with handlers and opts as for NewStateMachine. It returns
+// an error if a transition has no destination.
+func NewStateMachineFromJSON(data []byte, handlers Handlers, opts ...Option) (*StateMachine, error) {
+	var def definition
+	if err := json.Unmarshal(data, &def); err != nil {
+		return nil, err
+	}
+
+	events := make(Events, 0, len(def.Transitions))
+	for _, t := range def.Transitions {
+		if t.Dst == "" {
+			return nil, fmt.Errorf("transition %s has no destination", t.Name)
+		}
+		events = append(events, EventDesc{
+			Name:     t.Name,
+			Src:      t.Src,
+			Dst:      t.Dst,
+			Priority: t.Priority,
+			Weight:   t.Weight,
+			Terminal: t.Terminal,
+			Label:    t.Label,
+			Doc:      t.Doc,
+		})
+	}
+	machine := NewStateMachine(def.Initial, events, handlers, opts...)
+	for _, state := range def.States {
+		machine.AddState(state)
+	}
+	return machine, nil
+}
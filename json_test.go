@@ -0,0 +1,162 @@
+package statemachine
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestDefinitionJSON(t *testing.T) {
+	fsm := NewStateMachine(
+		"draft",
+		Events{
+			{Name: "submit", Src: []string{"draft"}, Dst: "review", Label: "submit for review"},
+			{Name: "approve", Src: []string{"review"}, Dst: "published", Terminal: true},
+			{Name: "reject", Src: []string{"review"}, Dst: "draft"},
+			{Name: "cancel", Src: []string{"draft", "review"}, Dst: "canceled"},
+		},
+		Handlers{},
+	)
+	fsm.AddState("archived")
+
+	data, err := fsm.DefinitionJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := NewStateMachineFromJSON(data, Handlers{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	added, removed := fsm.DiffTransitions(loaded)
+	if len(added) != 0 || len(removed) != 0 {
+		t.Fatalf("expected no differences, got %v and %v", added, removed)
+	}
+	if loaded.Current() != "draft" {
+		t.Fatalf("expected draft, got %s", loaded.Current())
+	}
+	if !sameStrings(loaded.States(), fsm.States()) {
+		t.Fatalf("expected states %v, got %v", fsm.States(), loaded.States())
+	}
+	if loaded.ToMermaid() != fsm.ToMermaid() {
+		t.Fatalf("expected\n%s\ngot\n%s", fsm.ToMermaid(), loaded.ToMermaid())
+	}
+
+	if _, err := NewStateMachineFromJSON([]byte("{"), Handlers{}); err == nil {
+		t.Fatal("expected an error for invalid JSON")
+	}
+}
+
+func TestDefinitionJSONComputedDestination(t *testing.T) {
+	fsm := NewStateMachine(
+		"start",
+		Events{
+			{Name: "pick", Src: []string{"start"}, DstFunc: func(args []interface{}) string {
+				return "end"
+			}},
+			{Name: "finish", Src: []string{"start"}, Dst: "end"},
+		},
+		Handlers{},
+	)
+	_, err := fsm.DefinitionJSON()
+	if err == nil || err.Error() != "transition pick from start has a computed destination that cannot be written as JSON" {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	data := []byte(`{"initial":"start","transitions":[{"name":"pick","src":["start"],"dst":""}]}`)
+	if _, err := NewStateMachineFromJSON(data, Handlers{}); err == nil || err.Error() != "transition pick has no destination" {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestDefinitionJSONFunctions(t *testing.T) {
+	fsm := NewStateMachine(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open", Guard: func(e *Event) bool {
+				return false
+			}},
+		},
+		Handlers{},
+	)
+	_, err := fsm.DefinitionJSON()
+	if err == nil || err.Error() != "transition open from closed has a guard that cannot be written as JSON" {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestDefinitionJSONGroupsByFields(t *testing.T) {
+	fsm := NewStateMachine(
+		"a",
+		Events{
+			{Name: "go", Src: []string{"a"}, Dst: "b"},
+			{Name: "reset", Src: []string{"a"}, Dst: "start", Label: "from a", Weight: 3},
+			{Name: "reset", Src: []string{"b"}, Dst: "start", Label: "from b", Weight: 1},
+		},
+		Handlers{},
+	)
+	data, err := fsm.DefinitionJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := NewStateMachineFromJSON(data, Handlers{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, src := range []string{"a", "b"} {
+		want := fsm.states[stateKey{"reset", src}][0]
+		got := loaded.states[stateKey{"reset", src}][0]
+		if got.Label != want.Label || got.Weight != want.Weight {
+			t.Fatalf("expected %q with weight %v from %s, got %q with weight %v", want.Label, want.Weight, src, got.Label, got.Weight)
+		}
+	}
+}
+
+func TestToGraphJSON(t *testing.T) {
+	fsm := NewStateMachine(
+		"green",
+		Events{
+			{Name: "warn", Src: []string{"green"}, Dst: "yellow"},
+			{Name: "panic", Src: []string{"yellow", "green"}, Dst: "red"},
+			{Name: "calm", Src: []string{"red"}, Dst: "yellow"},
+			{Name: "clear", Src: []string{"yellow"}, Dst: "green", Label: "all clear"},
+			{Name: "off", Src: []string{"red"}, Dst: "off"},
+		},
+		Handlers{},
+	)
+	fsm.Event("warn")
+
+	data, err := fsm.ToGraphJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var g struct {
+		Nodes []struct {
+			ID        string
+			IsCurrent bool
+			IsFinal   bool
+		}
+		Links []struct {
+			Source string
+			Target string
+			Label  string
+		}
+	}
+	if err := json.Unmarshal(data, &g); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(g.Nodes) != 4 || len(g.Links) != 6 {
+		t.Fatalf("expected 4 nodes and 6 links, got %s", data)
+	}
+	for _, node := range g.Nodes {
+		if node.IsCurrent != (node.ID == "yellow") || node.IsFinal != (node.ID == "off") {
+			t.Fatalf("unexpected flags of %s in %s", node.ID, data)
+		}
+	}
+	for _, link := range g.Links {
+		if link.Source == "yellow" && link.Target == "green" && link.Label != "all clear" {
+			t.Fatalf("expected the label of clear, got %s", data)
+		}
+	}
+}
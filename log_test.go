@@ -0,0 +1,84 @@
+package statemachine
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"testing"
+)
+
+// captureHandler is a slog.Handler that keeps a line per record.
+type captureHandler struct {
+	records *[]string
+}
+
+func (h captureHandler) Enabled(context.Context, slog.Level) bool {
+	return true
+}
+
+func (h captureHandler) Handle(_ context.Context, r slog.Record) error {
+	line := r.Level.String() + " " + r.Message
+	r.Attrs(func(a slog.Attr) bool {
+		line += " " + a.String()
+		return true
+	})
+	*h.records = append(*h.records, line)
+	return nil
+}
+
+func (h captureHandler) WithAttrs([]slog.Attr) slog.Handler {
+	return h
+}
+
+func (h captureHandler) WithGroup(string) slog.Handler {
+	return h
+}
+
+func TestSetLogger(t *testing.T) {
+	var records []string
+	fsm := NewStateMachine(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+			{Name: "reset", Src: []string{"end"}, Dst: "start"},
+		},
+		Handlers{
+			"before_reset": func(e *Event) {
+				e.Cancel()
+			},
+		},
+	)
+	fsm.SetLogger(slog.New(captureHandler{&records}))
+
+	fsm.Event("run")
+	fsm.Event("reset")
+
+	expected := []string{
+		"DEBUG running handlers phase=before event=run src=start dst=end",
+		"DEBUG running handlers phase=leave event=run src=start dst=end",
+		"DEBUG transition committed event=run src=start dst=end",
+		"DEBUG running handlers phase=enter event=run src=start dst=end",
+		"DEBUG running handlers phase=after event=run src=start dst=end",
+		"DEBUG running handlers phase=before event=reset src=end dst=start",
+		"DEBUG transition canceled phase=before event=reset src=end dst=start",
+	}
+	if fmt.Sprint(records) != fmt.Sprint(expected) {
+		t.Fatalf("expected\n%v\ngot\n%v", expected, records)
+	}
+}
+
+func TestNoLogger(t *testing.T) {
+	fsm := NewStateMachine(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+		},
+		Handlers{},
+	)
+	allocs := testing.AllocsPerRun(10, func() {
+		fsm.log("transition committed", &Event{Name: "run"})
+	})
+	if allocs != 0 {
+		t.Fatalf("expected no allocations without a logger, got %v", allocs)
+	}
+}
@@ -0,0 +1,74 @@
+package statemachine
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestMiddleware(t *testing.T) {
+	var calls []string
+	durations := make(map[string]time.Duration)
+	fsm := NewStateMachine(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+		},
+		Handlers{
+			"enter_end": func(e *Event) {
+				calls = append(calls, "enter_end")
+			},
+		},
+	)
+	fsm.Use(func(next func() error, e *Event) error {
+		calls = append(calls, "outer")
+		start := time.Now()
+		err := next()
+		durations[e.Name] = time.Since(start)
+		return err
+	})
+	fsm.Use(func(next func() error, e *Event) error {
+		calls = append(calls, "inner")
+		return next()
+	})
+
+	if err := fsm.Event("run"); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.Current() != "end" {
+		t.Fatalf("expected end, got %s", fsm.Current())
+	}
+	if _, ok := durations["run"]; !ok {
+		t.Fatal("expected a duration for run")
+	}
+	expected := []string{"outer", "inner", "enter_end"}
+	if fmt.Sprint(calls) != fmt.Sprint(expected) {
+		t.Fatalf("expected %v, got %v", expected, calls)
+	}
+}
+
+func TestMiddlewareAbort(t *testing.T) {
+	entered := false
+	fsm := NewStateMachine(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+		},
+		Handlers{
+			"enter_end": func(e *Event) {
+				entered = true
+			},
+		},
+	)
+	fsm.Use(func(next func() error, e *Event) error {
+		return fmt.Errorf("maintenance")
+	})
+
+	err := fsm.Event("run")
+	if err == nil || err.Error() != "maintenance" {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if fsm.Current() != "start" || entered {
+		t.Fatal("expected the transition to be aborted")
+	}
+}
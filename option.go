@@ -0,0 +1,124 @@
+package statemachine
+
+import (
+	"fmt"
+)
+
+// Option configures a StateMachine when passed to NewStateMachine.
+type Option func(*StateMachine)
+
+// HandlerOrder decides whether the named or the general handler of a phase
+// runs first.
+type HandlerOrder int
+
+const (
+	// SpecificFirst runs the named handler of a phase, such as before_run,
+	// before the general one, such as before_event. It is the default.
+	SpecificFirst HandlerOrder = iota
+
+	// GenericFirst runs the general handler of a phase before the named
+	// one, so that it can set up context for the named handler.
+	GenericFirst
+)
+
+// WithHandlerOrder sets the order in which the named and general handlers of
+// each phase are called. The order applies to the before_, leave_, enter_ and
+// after_ phases alike, also when the startState is asynchronous. Cancelling
+// the event or making it asynchronous in the first handler of the before_ or
+// leave_ phase skips the second one.
+func WithHandlerOrder(order HandlerOrder) Option {
+	return func(machine *StateMachine) {
+		machine.handlerOrder = order
+	}
+}
+
+// WithInitialState makes the machine start in state instead of the initial
+// state, for example when resuming a machine loaded from storage. The
+// initial state stays the start of the graph in exports and analyses such as
+// UnreachableStates. An unknown state is ignored, and reported as an error by
+// NewCheckedStateMachine.
+func WithInitialState(state string) Option {
+	return func(machine *StateMachine) {
+		if !machine.allStates[state] && state != machine.initial {
+			machine.optionErrs = append(machine.optionErrs, fmt.Errorf("initial state option %s is not a known state", state))
+			return
+		}
+		machine.current = state
+	}
+}
+
+// WithEnterRollback lets enter_ handlers undo a transition by cancelling its
+// event. The remaining enter_ handler is skipped, the rollback_<STATE>
+// handler of the entered state is called, the exit actions of that state
+// and the entry actions of the source run, and the machine moves back to the
+// source state. The after_ handlers are not called and the transition is
+// removed from the history and the transition and enter counts.
+//
+// Side effects of the handlers and actions that ran before the cancellation
+// are not undone; rollback_<STATE> is the place to compensate for them.
+// Without this option a cancellation in enter_ or after_ makes Event return a
+// LateCancelError.
+func WithEnterRollback() Option {
+	return func(machine *StateMachine) {
+		machine.enterRollback = true
+	}
+}
+
+// WithDeadEndCheck makes NewCheckedStateMachine report every destination
+// without outgoing transitions, counting those inherited from parent states,
+// unless a transition into it is marked Terminal. Such dead ends are often
+// typos in a Dst, but ordinary sink states must then be marked. It has no
+// effect on NewStateMachine.
+func WithDeadEndCheck() Option {
+	return func(machine *StateMachine) {
+		machine.deadEndCheck = true
+	}
+}
+
+// WithSelfTransitionHandlers makes transitions whose destination is their
+// source call the enter_ and after_ handlers, in that order. The before_ and
+// leave_ handlers are not called. By default such transitions only run the
+// exit and entry actions of the state.
+func WithSelfTransitionHandlers() Option {
+	return func(machine *StateMachine) {
+		machine.selfHandlers = true
+	}
+}
+
+// defaultMaxChainDepth is the chain depth used unless WithMaxChainDepth is
+// given.
+const defaultMaxChainDepth = 16
+
+// WithMaxChainDepth sets how many events may follow each other through
+// Event.Goto before the chain is stopped with an error, to guard against
+// states that route to each other forever. The default is 16.
+func WithMaxChainDepth(depth int) Option {
+	return func(machine *StateMachine) {
+		machine.maxChainDepth = depth
+	}
+}
+
+// InProgressPolicy decides what happens to an event fired while an
+// asynchronous startState is waiting for Excute.
+type InProgressPolicy int
+
+const (
+	// Reject fails the event with an InTransitionError. It is the default.
+	Reject InProgressPolicy = iota
+
+	// Queue keeps the event and fires it once the pending startState has
+	// been completed with Excute. Event returns nil for a queued event.
+	Queue
+
+	// CancelPending drops the pending startState, leaving the machine in its
+	// source state, and fires the event from there.
+	CancelPending
+)
+
+// WithInProgressPolicy sets how events fired while an asynchronous startState
+// is pending are handled.
+func WithInProgressPolicy(policy InProgressPolicy) Option {
+	return func(machine *StateMachine) {
+		machine.inProgress = policy
+	}
+}
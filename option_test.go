@@ -0,0 +1,297 @@
+package statemachine
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// record returns a handler that appends name to calls.
+func record(calls *[]string, name string) Handler {
+	return func(e *Event) {
+		*calls = append(*calls, name)
+	}
+}
+
+func newOrderedMachine(calls *[]string, opts ...Option) *StateMachine {
+	return NewStateMachine(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+		},
+		Handlers{
+			"before_run":   record(calls, "before_run"),
+			"before_event": record(calls, "before_event"),
+			"leave_start":  record(calls, "leave_start"),
+			"leave_state":  record(calls, "leave_state"),
+			"enter_end":    record(calls, "enter_end"),
+			"enter_state":  record(calls, "enter_state"),
+			"after_run":    record(calls, "after_run"),
+			"after_event":  record(calls, "after_event"),
+		},
+		opts...,
+	)
+}
+
+func TestHandlerOrderSpecificFirst(t *testing.T) {
+	var calls []string
+	fsm := newOrderedMachine(&calls)
+	fsm.Event("run")
+
+	expected := []string{
+		"before_run", "before_event",
+		"leave_start", "leave_state",
+		"enter_end", "enter_state",
+		"after_run", "after_event",
+	}
+	if fmt.Sprint(calls) != fmt.Sprint(expected) {
+		t.Fatalf("expected %v, got %v", expected, calls)
+	}
+}
+
+func TestHandlerOrderGenericFirst(t *testing.T) {
+	var calls []string
+	fsm := newOrderedMachine(&calls, WithHandlerOrder(GenericFirst))
+	fsm.Event("run")
+
+	expected := []string{
+		"before_event", "before_run",
+		"leave_state", "leave_start",
+		"enter_state", "enter_end",
+		"after_event", "after_run",
+	}
+	if fmt.Sprint(calls) != fmt.Sprint(expected) {
+		t.Fatalf("expected %v, got %v", expected, calls)
+	}
+}
+
+func TestHandlerOrderGenericFirstAsync(t *testing.T) {
+	var calls []string
+	fsm := newOrderedMachine(&calls, WithHandlerOrder(GenericFirst))
+	fsm.handlers[handlerKey{"", leaveState}] = []Handler{func(e *Event) {
+		calls = append(calls, "leave_state")
+		e.Async()
+	}}
+	fsm.Event("run")
+	if err := fsm.Excute(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"before_event", "before_run",
+		"leave_state",
+		"enter_state", "enter_end",
+		"after_event", "after_run",
+	}
+	if fmt.Sprint(calls) != fmt.Sprint(expected) {
+		t.Fatalf("expected %v, got %v", expected, calls)
+	}
+}
+
+func TestHandlerOrderGenericFirstCancel(t *testing.T) {
+	var calls []string
+	fsm := newOrderedMachine(&calls, WithHandlerOrder(GenericFirst))
+	fsm.handlers[handlerKey{"", beforeEvent}] = []Handler{func(e *Event) {
+		calls = append(calls, "before_event")
+		e.Cancel()
+	}}
+	fsm.Event("run")
+
+	if fmt.Sprint(calls) != "[before_event]" {
+		t.Fatalf("expected only before_event, got %v", calls)
+	}
+	if fsm.Current() != "start" {
+		t.Fatalf("expected start, got %s", fsm.Current())
+	}
+}
+
+func newInProgressMachine(policy InProgressPolicy) *StateMachine {
+	return NewStateMachine(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+			{Name: "skip", Src: []string{"start"}, Dst: "skipped"},
+			{Name: "finish", Src: []string{"end"}, Dst: "finished"},
+		},
+		Handlers{
+			"leave_start": func(e *Event) {
+				if e.Name == "run" {
+					e.Async()
+				}
+			},
+		},
+		WithInProgressPolicy(policy),
+	)
+}
+
+func TestInProgressReject(t *testing.T) {
+	fsm := newInProgressMachine(Reject)
+	fsm.Event("run")
+
+	err := fsm.Event("skip")
+	if _, ok := err.(*InTransitionError); !ok {
+		t.Fatalf("expected InTransitionError, got %v", err)
+	}
+	if err := fsm.Excute(); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.Current() != "end" {
+		t.Fatalf("expected end, got %s", fsm.Current())
+	}
+}
+
+func TestInProgressQueue(t *testing.T) {
+	fsm := newInProgressMachine(Queue)
+	fsm.Event("run")
+
+	if err := fsm.Event("finish"); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.Current() != "start" {
+		t.Fatalf("expected start, got %s", fsm.Current())
+	}
+	if err := fsm.Excute(); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.Current() != "finished" {
+		t.Fatalf("expected finished, got %s", fsm.Current())
+	}
+}
+
+func TestInProgressCancelPending(t *testing.T) {
+	fsm := newInProgressMachine(CancelPending)
+	fsm.Event("run")
+
+	if err := fsm.Event("skip"); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.Current() != "skipped" {
+		t.Fatalf("expected skipped, got %s", fsm.Current())
+	}
+	if _, ok := fsm.Excute().(*NotInTransitionError); !ok {
+		t.Fatal("expected the pending transition to be dropped")
+	}
+}
+
+func TestWithInitialState(t *testing.T) {
+	events := Events{
+		{Name: "submit", Src: []string{"draft"}, Dst: "review"},
+		{Name: "approve", Src: []string{"review"}, Dst: "published"},
+	}
+	fsm := NewStateMachine("draft", events, Handlers{}, WithInitialState("review"))
+	if fsm.Current() != "review" {
+		t.Fatalf("expected review, got %s", fsm.Current())
+	}
+	if err := fsm.Event("approve"); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.Current() != "published" {
+		t.Fatalf("expected published, got %s", fsm.Current())
+	}
+
+	fsm = NewStateMachine("draft", events, Handlers{}, WithInitialState("reveiw"))
+	if fsm.Current() != "draft" {
+		t.Fatalf("expected draft, got %s", fsm.Current())
+	}
+	_, err := NewCheckedStateMachine("draft", events, Handlers{}, WithInitialState("reveiw"))
+	if err == nil || err.Error() != "initial state option reveiw is not a known state" {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func newRollbackMachine(calls *[]string, opts ...Option) *StateMachine {
+	return NewStateMachine(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+		},
+		Handlers{
+			"enter_end": func(e *Event) {
+				*calls = append(*calls, "enter_end")
+				e.CancelWithError(fmt.Errorf("no room"))
+			},
+			"enter_state":  record(calls, "enter_state"),
+			"after_run":    record(calls, "after_run"),
+			"rollback_end": record(calls, "rollback_end"),
+		},
+		opts...,
+	)
+}
+
+func TestLateCancel(t *testing.T) {
+	var calls []string
+	fsm := newRollbackMachine(&calls)
+
+	err := fsm.Event("run")
+	var late *LateCancelError
+	if !errors.As(err, &late) || err.Error() != "event run canceled after entering end: no room" {
+		t.Fatalf("expected LateCancelError, got %v", err)
+	}
+	if fsm.Current() != "end" {
+		t.Fatalf("expected end, got %s", fsm.Current())
+	}
+	expected := []string{"enter_end", "enter_state", "after_run"}
+	if fmt.Sprint(calls) != fmt.Sprint(expected) {
+		t.Fatalf("expected %v, got %v", expected, calls)
+	}
+}
+
+func TestEnterRollback(t *testing.T) {
+	var calls []string
+	fsm := newRollbackMachine(&calls, WithEnterRollback())
+	fsm.OnEntry("start", func() {
+		calls = append(calls, "entry start")
+	})
+
+	err := fsm.Event("run")
+	if err == nil || err.Error() != "no room" {
+		t.Fatalf("expected the cancel error, got %v", err)
+	}
+	if fsm.Current() != "start" {
+		t.Fatalf("expected start, got %s", fsm.Current())
+	}
+	expected := []string{"enter_end", "rollback_end", "entry start"}
+	if fmt.Sprint(calls) != fmt.Sprint(expected) {
+		t.Fatalf("expected %v, got %v", expected, calls)
+	}
+	if changes, _ := fsm.ChangesSince(""); len(changes) != 0 || fsm.TransitionCount() != 0 {
+		t.Fatalf("expected the transition to be forgotten, got %v", changes)
+	}
+}
+
+func newSelfTransitionMachine(calls *[]string, opts ...Option) *StateMachine {
+	return NewStateMachine(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "start"},
+		},
+		Handlers{
+			"leave_start": record(calls, "leave_start"),
+			"enter_start": record(calls, "enter_start"),
+			"after_run":   record(calls, "after_run"),
+		},
+		opts...,
+	)
+}
+
+func TestSelfTransitionSilent(t *testing.T) {
+	var calls []string
+	fsm := newSelfTransitionMachine(&calls)
+	if err := fsm.Event("run"); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.Current() != "start" || len(calls) != 0 {
+		t.Fatalf("expected a silent no-op, got %s, %v", fsm.Current(), calls)
+	}
+}
+
+func TestSelfTransitionHandlers(t *testing.T) {
+	var calls []string
+	fsm := newSelfTransitionMachine(&calls, WithSelfTransitionHandlers())
+	if err := fsm.Event("run"); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.Current() != "start" || fmt.Sprint(calls) != "[enter_start after_run]" {
+		t.Fatalf("expected the enter_ and after_ handlers, got %s, %v", fsm.Current(), calls)
+	}
+}
@@ -0,0 +1,46 @@
+package statemachine
+
+import (
+	"fmt"
+)
+
+// InvocationRecorder captures the order in which handlers run, for use in
+// golden-file style regression tests.
+type InvocationRecorder struct {
+	invocations []string
+}
+
+// RecordInvocations starts recording handler invocations and returns the
+// recorder. Any previously started recorder stops receiving invocations.
+//
+// Each invocation is recorded as the long form of the handler name, such as
+// "before_run" or "enter_state", even if the handler was registered using the
+// short form.
+func (machine *StateMachine) RecordInvocations() *InvocationRecorder {
+	machine.recorder = &InvocationRecorder{}
+	return machine.recorder
+}
+
+// Invocations returns the handler names recorded so far, in order.
+func (recorder *InvocationRecorder) Invocations() []string {
+	invocations := make([]string, len(recorder.invocations))
+	copy(invocations, recorder.invocations)
+	return invocations
+}
+
+// Compare returns nil if the recorded invocations equal golden, otherwise an
+// error describing the first difference.
+func (recorder *InvocationRecorder) Compare(golden []string) error {
+	for i, got := range recorder.invocations {
+		if i >= len(golden) {
+			return fmt.Errorf("invocation %d: got unexpected %s, want end of sequence", i, got)
+		}
+		if got != golden[i] {
+			return fmt.Errorf("invocation %d: got %s, want %s", i, got, golden[i])
+		}
+	}
+	if len(golden) > len(recorder.invocations) {
+		return fmt.Errorf("invocation %d: got end of sequence, want %s", len(recorder.invocations), golden[len(recorder.invocations)])
+	}
+	return nil
+}
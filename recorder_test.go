@@ -0,0 +1,72 @@
+package statemachine
+
+import (
+	"testing"
+)
+
+func newRecordedMachine() *StateMachine {
+	noop := func(e *Event) {}
+	return NewStateMachine(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+		},
+		Handlers{
+			"before_run":   noop,
+			"before_event": noop,
+			"leave_start":  noop,
+			"leave_state":  noop,
+			"end":          noop,
+			"enter_state":  noop,
+			"run":          noop,
+			"after_event":  noop,
+		},
+	)
+}
+
+func TestRecordInvocations(t *testing.T) {
+	fsm := newRecordedMachine()
+	recorder := fsm.RecordInvocations()
+	fsm.Event("run")
+
+	golden := []string{
+		"before_run",
+		"before_event",
+		"leave_start",
+		"leave_state",
+		"enter_end",
+		"enter_state",
+		"after_run",
+		"after_event",
+	}
+	if err := recorder.Compare(golden); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRecordInvocationsMismatch(t *testing.T) {
+	fsm := newRecordedMachine()
+	recorder := fsm.RecordInvocations()
+	fsm.Event("run")
+
+	err := recorder.Compare([]string{
+		"before_run",
+		"before_event",
+		"leave_start",
+		"leave_state",
+		"after_run",
+	})
+	if err == nil || err.Error() != "invocation 4: got enter_end, want after_run" {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	err = recorder.Compare(append(recorder.Invocations(), "after_run"))
+	if err == nil || err.Error() != "invocation 8: got end of sequence, want after_run" {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	err = recorder.Compare(recorder.Invocations()[:2])
+	if err == nil || err.Error() != "invocation 2: got unexpected leave_start, want end of sequence" {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
@@ -0,0 +1,46 @@
+package statemachine
+
+import (
+	"sync"
+)
+
+// Registry holds machines by id, for example one per entity of a service.
+// It only guards its own map: the methods of Registry can be called from any
+// goroutine, but the machines it returns are no more safe for concurrent use
+// than any other machine. The zero value is an empty registry ready to use.
+type Registry struct {
+	mu       sync.Mutex
+	machines map[string]*StateMachine
+}
+
+// Get returns the machine stored under id, or nil if there is none.
+func (registry *Registry) Get(id string) *StateMachine {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	return registry.machines[id]
+}
+
+// GetOrCreate returns the machine stored under id. If there is none, it calls
+// factory and stores the machine it returns under id first. Concurrent calls
+// for the same id call factory only once and all return the same machine.
+// factory must not call the methods of the registry.
+func (registry *Registry) GetOrCreate(id string, factory func() *StateMachine) *StateMachine {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	if machine, ok := registry.machines[id]; ok {
+		return machine
+	}
+	if registry.machines == nil {
+		registry.machines = make(map[string]*StateMachine)
+	}
+	machine := factory()
+	registry.machines[id] = machine
+	return machine
+}
+
+// Delete removes the machine stored under id, if any.
+func (registry *Registry) Delete(id string) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	delete(registry.machines, id)
+}
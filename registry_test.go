@@ -0,0 +1,57 @@
+package statemachine
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	var registry Registry
+	var created atomic.Int32
+	factory := func() *StateMachine {
+		created.Add(1)
+		return NewStateMachine(
+			"closed",
+			Events{
+				{Name: "open", Src: []string{"closed"}, Dst: "open"},
+			},
+			Handlers{},
+		)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			id := strconv.Itoa(i % 10)
+			machine := registry.GetOrCreate(id, factory)
+			if registry.Get(id) != machine {
+				t.Errorf("expected the machine of %s to be stored", id)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if created.Load() != 10 {
+		t.Fatalf("expected 10 machines, got %d", created.Load())
+	}
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			registry.Delete(strconv.Itoa(i))
+		}(i)
+	}
+	wg.Wait()
+	for i := 0; i < 10; i++ {
+		if registry.Get(strconv.Itoa(i)) != nil {
+			t.Fatalf("expected machine %d to be deleted", i)
+		}
+	}
+	if registry.Get("missing") != nil {
+		t.Fatal("expected nil for an unknown id")
+	}
+}
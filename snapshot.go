@@ -0,0 +1,118 @@
+package statemachine
+
+import (
+	"fmt"
+)
+
+// Snapshot is the serializable state of a machine, including an asynchronous
+// startState that is waiting for Excute. The pending fields are empty when no
+// startState is pending.
+type Snapshot struct {
+	Current      string
+	PendingEvent string
+	PendingDst   string
+	PendingArgs  []interface{}
+}
+
+// Snapshot returns the current state of the machine and its pending
+// startState, if any.
+func (machine *StateMachine) Snapshot() Snapshot {
+	snapshot := Snapshot{Current: machine.Current()}
+	if event := machine.pending; machine.startState != nil && event != nil {
+		snapshot.PendingEvent = event.Name
+		snapshot.PendingDst = event.Dst
+		snapshot.PendingArgs = append([]interface{}(nil), event.Args...)
+	}
+	return snapshot
+}
+
+// Restore puts the machine in the state described by snapshot, which is
+// usually taken from a machine with the same definition. A pending startState
+// is rebuilt so that Excute completes it, calling the enter_ and after_
+// handlers; its before_ and leave_ handlers are not called again. The entry
+// actions of the restored state are run, see OnEntry, and the data of the
+// previous state is discarded, see Event.SetStateData.
+//
+// Restore returns an error if a startState is already pending, if a state is
+// unknown or if the pending event is not defined from the restored state.
+func (machine *StateMachine) Restore(snapshot Snapshot) error {
+	if machine.startState != nil {
+		return fmt.Errorf("restore inappropriate because previous startState did not complete")
+	}
+	if !machine.allStates[snapshot.Current] && snapshot.Current != machine.initial {
+		return fmt.Errorf("restore to unknown state %s", snapshot.Current)
+	}
+
+	var event *Event
+	if snapshot.PendingEvent != "" {
+		candidates, ok := machine.candidates(snapshot.PendingEvent, snapshot.Current)
+		if !ok {
+			return &InvalidEventError{snapshot.PendingEvent, snapshot.Current}
+		}
+		if !machine.allStates[snapshot.PendingDst] {
+			return fmt.Errorf("restore of event %s to unknown state %s", snapshot.PendingEvent, snapshot.PendingDst)
+		}
+
+		event = &Event{
+			StateMachine: machine,
+			Name:         snapshot.PendingEvent,
+			Src:          snapshot.Current,
+			Dst:          snapshot.PendingDst,
+			Args:         snapshot.PendingArgs,
+			async:        true,
+		}
+		for _, desc := range candidates {
+			if desc.Dst == snapshot.PendingDst || desc.DstFunc != nil || desc.DstFromSrc != nil {
+				event.desc = desc
+				break
+			}
+		}
+	}
+
+	machine.setStateData(nil)
+	machine.setCurrent(snapshot.Current)
+	machine.runActions(machine.entry[snapshot.Current])
+	if event != nil {
+		machine.setPending(event)
+	}
+	return nil
+}
+
+// SetState forces the machine into state without a transition, for example
+// in tests or to recover from an external failure. The enter_ handlers of
+// state are called with an event that has an empty name, Src set to the
+// previous state and Dst to state, and the entry actions of state are run.
+// No before_, leave_ or after_ handlers are called and nothing is added to
+// the history.
+//
+// SetState returns an error if state is unknown or if a startState is
+// pending.
+func (machine *StateMachine) SetState(state string) error {
+	src := machine.Current()
+	if err := machine.forceState(state); err != nil {
+		return err
+	}
+	event := &Event{StateMachine: machine, Src: src, Dst: state}
+	machine.runActions(machine.entry[state])
+	machine.callPhase(state, enterState, event)
+	return nil
+}
+
+// SetStateQuiet forces the machine into state like SetState, but calls no
+// handlers and runs no entry actions.
+func (machine *StateMachine) SetStateQuiet(state string) error {
+	return machine.forceState(state)
+}
+
+// forceState validates state and makes it the current state.
+func (machine *StateMachine) forceState(state string) error {
+	if machine.startState != nil {
+		return fmt.Errorf("set state inappropriate because previous startState did not complete")
+	}
+	if !machine.allStates[state] && state != machine.initial {
+		return fmt.Errorf("set state to unknown state %s", state)
+	}
+	machine.setStateData(nil)
+	machine.setCurrent(state)
+	return nil
+}
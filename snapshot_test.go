@@ -0,0 +1,141 @@
+package statemachine
+
+import (
+	"fmt"
+	"testing"
+)
+
+func newSnapshotMachine(enterArgs *[]interface{}) *StateMachine {
+	return NewStateMachine(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+			{Name: "reset", Src: []string{"end"}, Dst: "start"},
+		},
+		Handlers{
+			"leave_start": func(e *Event) {
+				e.Async()
+			},
+			"enter_end": func(e *Event) {
+				*enterArgs = e.Args
+			},
+		},
+	)
+}
+
+func TestSnapshotRestorePending(t *testing.T) {
+	var args []interface{}
+	fsm := newSnapshotMachine(&args)
+	fsm.Event("run", "fast")
+
+	snapshot := fsm.Snapshot()
+	expected := Snapshot{Current: "start", PendingEvent: "run", PendingDst: "end", PendingArgs: []interface{}{"fast"}}
+	if fmt.Sprint(snapshot) != fmt.Sprint(expected) {
+		t.Fatalf("expected %v, got %v", expected, snapshot)
+	}
+
+	restored := newSnapshotMachine(&args)
+	if err := restored.Restore(snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if err := restored.Event("reset"); err == nil {
+		t.Fatal("expected the restored transition to be pending")
+	}
+	if err := restored.Excute(); err != nil {
+		t.Fatal(err)
+	}
+	if restored.Current() != "end" {
+		t.Fatalf("expected end, got %s", restored.Current())
+	}
+	if fmt.Sprint(args) != "[fast]" {
+		t.Fatalf("expected the pending args to be restored, got %v", args)
+	}
+}
+
+func TestRestoreErrors(t *testing.T) {
+	var args []interface{}
+	fsm := newSnapshotMachine(&args)
+	if err := fsm.Restore(Snapshot{Current: "nowhere"}); err == nil {
+		t.Fatal("expected an error for an unknown state")
+	}
+	if err := fsm.Restore(Snapshot{Current: "end", PendingEvent: "run", PendingDst: "end"}); err == nil {
+		t.Fatal("expected an error for an event not defined from the state")
+	}
+	if err := fsm.Restore(Snapshot{Current: "end"}); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.Current() != "end" {
+		t.Fatalf("expected end, got %s", fsm.Current())
+	}
+}
+
+func TestSetState(t *testing.T) {
+	var entered []string
+	fsm := NewStateMachine(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+		},
+		Handlers{
+			"enter_end": func(e *Event) {
+				entered = append(entered, e.Src+">"+e.Dst)
+			},
+		},
+	)
+
+	if err := fsm.SetState("end"); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.Current() != "end" {
+		t.Fatalf("expected end, got %s", fsm.Current())
+	}
+	if fmt.Sprint(entered) != "[start>end]" {
+		t.Fatalf("expected enter_end to be called, got %v", entered)
+	}
+
+	if err := fsm.SetStateQuiet("start"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fsm.SetStateQuiet("end"); err != nil {
+		t.Fatal(err)
+	}
+	if len(entered) != 1 {
+		t.Fatalf("expected no handlers to be called, got %v", entered)
+	}
+	if changes, _ := fsm.ChangesSince(""); len(changes) != 0 {
+		t.Fatalf("expected no history, got %v", changes)
+	}
+}
+
+func TestSetStateUnknown(t *testing.T) {
+	fsm := NewStateMachine(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+		},
+		Handlers{},
+	)
+	err := fsm.SetState("missing")
+	if err == nil || err.Error() != "set state to unknown state missing" {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if fsm.Current() != "start" {
+		t.Fatalf("expected start, got %s", fsm.Current())
+	}
+}
+
+func TestSetStatePending(t *testing.T) {
+	var args []interface{}
+	fsm := newSnapshotMachine(&args)
+	fsm.Event("run")
+
+	if err := fsm.SetState("end"); err == nil {
+		t.Fatal("expected an error while a transition is pending")
+	}
+	if err := fsm.SetStateQuiet("end"); err == nil {
+		t.Fatal("expected an error while a transition is pending")
+	}
+	if fsm.Current() != "start" {
+		t.Fatalf("expected start, got %s", fsm.Current())
+	}
+}
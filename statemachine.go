@@ -1,22 +1,163 @@
 package statemachine
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log/slog"
+	"math/rand"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 type StateMachine struct {
-	current    string
-	states     map[stateKey]string
-	handlers   map[handlerKey]Handler
+	initial  string
+	current  string
+	states   map[stateKey][]*EventDesc
+	handlers map[handlerKey][]Handler
+	// priorities holds the priorities of the handlers of each key, see
+	// AddHandlerWithPriority. Missing entries are 0.
+	priorities map[handlerKey][]int
 	startState func()
+	// pending is the event of startState while it is set.
+	pending *Event
+
+	// allStates and allEvents are the sets of known states and events, and
+	// except holds the transitions declared with SrcExcept or SrcPattern.
+	allStates map[string]bool
+	allEvents map[string]bool
+	except    []*EventDesc
+
+	// stateMu guards current, startState, pending, transitionCount, entries
+	// and stateData for readers on other goroutines, see Inspect, and
+	// stateCond is broadcast whenever current changes.
+	stateMu   sync.RWMutex
+	stateCond *sync.Cond
+
+	// entries counts the changes of current, so that a timer of AfterDelay
+	// can tell whether the state it was started for has been left since.
+	entries uint64
+
+	// driveMu is held by the timers of AfterDelay while they fire, see
+	// Locker.
+	driveMu sync.Mutex
+
+	// stateData is owned by the current state, see Event.SetStateData.
+	stateData interface{}
+
+	// history holds the most recent committed transitions, the first of
+	// which is transition number historyStart.
+	history      []Transition
+	historyStart uint64
+
+	// transitionCount is the number of committed transitions and
+	// enterCount the number of those that entered each state.
+	transitionCount uint64
+	enterCount      map[string]int
+
+	// queue holds events fired from handlers while queueEnabled is set,
+	// and firing is set while a transition is being performed.
+	queue        []*Event
+	queueEnabled bool
+	firing       bool
+
+	// committing is set while the startState closure runs.
+	committing bool
+
+	// inProgress decides what happens to events fired while a startState
+	// is pending.
+	inProgress InProgressPolicy
+
+	// onReject is called for every failed event if set.
+	onReject func(event, state string, err error)
+
+	// onQueue is called for every queued event if set.
+	onQueue func(event string, depth int)
+
+	// onUnknown handles events that are not defined if set.
+	onUnknown func(event string, args []interface{}) error
+
+	// entry and exit hold the actions registered with OnEntry and OnExit.
+	entry map[string][]func()
+	exit  map[string][]func()
+
+	// middleware wraps every transition, outermost first.
+	middleware []Middleware
+
+	// aliases maps alternative event names to the events they stand for.
+	aliases map[string]string
+
+	// macros maps macro names to the events they expand to.
+	macros map[string][]string
+
+	// traversed and invoked track which transitions and handlers have been
+	// used, for UsageReport.
+	traversed map[edge]bool
+	invoked   map[handlerKey]bool
+
+	// logger receives debug records of every phase if set.
+	logger *slog.Logger
+
+	// maxChainDepth limits how many events can follow each other through
+	// Event.Goto.
+	maxChainDepth int
+
+	// optionErrs are the errors of invalid options, reported by
+	// NewCheckedStateMachine.
+	optionErrs []error
+
+	// enterRollback is set if cancelling in enter_ undoes the transition.
+	enterRollback bool
+
+	// deadEndCheck is set if NewCheckedStateMachine reports dead ends.
+	deadEndCheck bool
+
+	// selfHandlers is set if self-transitions run the enter_ and after_
+	// handlers.
+	selfHandlers bool
+
+	// handlerOrder is the order of named and general handlers.
+	handlerOrder HandlerOrder
+
+	// clock is the source of time, see SetClock.
+	clock Clock
+
+	// debounce holds the windows set with Debounce and lastCommitted the
+	// time each event last committed a transition.
+	debounce      map[string]time.Duration
+	lastCommitted map[string]time.Time
+
+	// delays holds the timers of AfterDelay by state, so that RenameState
+	// can rename them.
+	delays map[string][]*delay
+
+	// random chooses between weighted transitions if set.
+	random *rand.Rand
+
+	// watchers are the channels returned by Watch, guarded by watchMu.
+	watchers []chan Transition
+	watchMu  sync.Mutex
+
+	// recorder captures handler invocations if set.
+	recorder *InvocationRecorder
+
+	// metadata is shared data for handlers, guarded by metadataMu.
+	metadata   map[string]interface{}
+	metadataMu sync.RWMutex
 }
 
 // NewStateMachine constructs a StateMachine from events and handlers.
 //
 // The events and states are specified as a slice of Event structs
 // specified as Events. Each Event is mapped to one or more internal
-// states from Event.Src to Event.Dst.
+// states from Event.Src to Event.Dst. Several Event structs may share a name
+// and lead to different destinations from different sources. They may even
+// share a source when they are told apart by a Guard; the first one whose
+// guard passes is taken, trying higher Priority first and otherwise in the
+// order they are declared.
 //
 // Handlers are added as a map specified as Handlers where the key is parsed
 // as the callback event as follows, and called in the same order:
@@ -37,6 +178,17 @@ type StateMachine struct {
 //
 // 8. after_event - called after all events
 //
+// 9. transition - called once after every committed transition, whichever
+// the event and states
+//
+// 10. final - called after a transition into a final state, one with no
+// outgoing transitions
+//
+// The error handler is called whenever another handler sets the Err field of
+// an event that had none, or cancels it with an error, so that errors can be
+// logged or wrapped in one place. The rollback_<STATE> and abort_<STATE>
+// handlers are described at WithEnterRollback and AbortAsync.
+//
 // There are also two short form versions for the most commonly used handlers.
 // They are simply the name of the event or state:
 //
@@ -44,105 +196,563 @@ type StateMachine struct {
 //
 // 2. <EVENT> - called after event named <EVENT>
 //
-// If both a shorthand version and a full version is specified it is undefined
-// which version of the callback will end up in the internal map. This is due
-// to the psuedo random nature of Go maps. No checking for multiple keys is
-// currently performed.
-func NewStateMachine(initial string, events Events, handlers Handlers) *StateMachine {
+// A state or event named transition, final or error is resolved as the
+// shorthand, so the hook of that name cannot be registered for such a
+// machine.
+//
+// States can be nested by separating their names with dots. An event that is
+// not defined for a state such as "active.running" is looked up for its
+// parent "active" and so on, while Current still returns the full name.
+// Graph analyses such as FinalStates, PathTo and UsageReport follow the same
+// lookup, and a state counts as reached along with its parents. Exports draw
+// inherited transitions once, from the parent that declares them.
+//
+// Nil handlers are ignored.
+//
+// If both a shorthand version and a full version is specified, the full
+// version is used and the shorthand version is ignored, regardless of the
+// pseudo random order in which Go iterates over maps.
+//
+// The behaviour of the machine can be adjusted with options such as
+// WithHandlerOrder.
+func NewStateMachine(initial string, events Events, handlers Handlers, opts ...Option) *StateMachine {
 	var machine StateMachine
+	machine.initial = initial
 	machine.current = initial
-	machine.states = make(map[stateKey]string)
-	machine.handlers = make(map[handlerKey]Handler)
+	machine.stateCond = sync.NewCond(&machine.stateMu)
+	machine.states = make(map[stateKey][]*EventDesc)
+	machine.handlers = make(map[handlerKey][]Handler)
+	machine.priorities = make(map[handlerKey][]int)
+	machine.metadata = make(map[string]interface{})
+	machine.macros = make(map[string][]string)
+	machine.aliases = make(map[string]string)
+	machine.traversed = make(map[edge]bool)
+	machine.invoked = make(map[handlerKey]bool)
+	machine.enterCount = make(map[string]int)
+	machine.entry = make(map[string][]func())
+	machine.exit = make(map[string][]func())
+	machine.maxChainDepth = defaultMaxChainDepth
+	machine.clock = realClock{}
+	machine.debounce = make(map[string]time.Duration)
+	machine.lastCommitted = make(map[string]time.Time)
+	machine.delays = make(map[string][]*delay)
 
 	// Build startState map and store sets of all events and states.
 	allEvents := make(map[string]bool)
 	allStates := make(map[string]bool)
-	for _, event := range events {
+	machine.allStates = allStates
+	machine.allEvents = allEvents
+	for i := range events {
+		event := events[i]
 		for _, src := range event.Src {
-			machine.states[stateKey{event.Name, src}] = event.Dst
+			key := stateKey{event.Name, src}
+			machine.states[key] = insertCandidate(machine.states[key], &event)
 			allStates[src] = true
-			allStates[event.Dst] = true
+		}
+		if len(event.Src) == 0 && (len(event.SrcExcept) > 0 || event.SrcPattern != nil) {
+			machine.except = append(machine.except, &event)
+		}
+		if len(event.Src) > 0 || len(event.SrcExcept) > 0 || event.SrcPattern != nil {
+			if event.DstFunc == nil && event.DstFromSrc == nil || event.Dst != "" {
+				allStates[event.Dst] = true
+			}
 		}
 		allEvents[event.Name] = true
 	}
+	for state := range allStates {
+		machine.expandExcept(state)
+	}
 
 	// Map all handlers to events/states.
 	for handlerName, handler := range handlers {
-		var target string
-		var handlerType handlerType
-
-		switch {
-		case strings.HasPrefix(handlerName, "before_"):
-			target = strings.TrimPrefix(handlerName, "before_")
-			if target == "event" {
-				target = ""
-				handlerType = beforeEvent
-			} else if _, ok := allEvents[target]; ok {
-				handlerType = beforeEvent
-			}
-		case strings.HasPrefix(handlerName, "leave_"):
-			target = strings.TrimPrefix(handlerName, "leave_")
-			if target == "state" {
-				target = ""
-				handlerType = leaveState
-			} else if _, ok := allStates[target]; ok {
-				handlerType = leaveState
-			}
-		case strings.HasPrefix(handlerName, "enter_"):
-			target = strings.TrimPrefix(handlerName, "enter_")
-			if target == "state" {
-				target = ""
-				handlerType = enterState
-			} else if _, ok := allStates[target]; ok {
-				handlerType = enterState
-			}
-		case strings.HasPrefix(handlerName, "after_"):
-			target = strings.TrimPrefix(handlerName, "after_")
-			if target == "event" {
-				target = ""
-				handlerType = afterEvent
-			} else if _, ok := allEvents[target]; ok {
-				handlerType = afterEvent
+		key, ok := resolveHandler(handlerName, allEvents, allStates)
+		if !ok || handler == nil {
+			continue
+		}
+		if _, exists := machine.handlers[key]; exists && handlerName != key.String() {
+			// A shorthand name never replaces the full name.
+			continue
+		}
+		machine.handlers[key] = []Handler{handler}
+	}
+
+	for _, opt := range opts {
+		opt(&machine)
+	}
+
+	return &machine
+}
+
+// AddState declares a state that does not need to appear in any transition,
+// such as a terminal state that is only ever entered. Declared states are
+// included in exports even if no transition touches them.
+func (machine *StateMachine) AddState(name string) {
+	if machine.allStates[name] {
+		return
+	}
+	machine.allStates[name] = true
+	machine.expandExcept(name)
+}
+
+// RenameState renames the state oldName to newName throughout the machine:
+// in every transition, in the handlers, actions and delayed events registered
+// for it, in the counters and as the initial, current or pending state. Past
+// entries of the history keep the old name, and nested states such as
+// "old.child" are not renamed.
+//
+// RenameState returns an error if oldName is not a known state or newName
+// already is one.
+func (machine *StateMachine) RenameState(oldName, newName string) error {
+	if oldName == newName {
+		return nil
+	}
+	if !machine.allStates[oldName] && oldName != machine.initial {
+		return fmt.Errorf("rename of unknown state %s", oldName)
+	}
+	if machine.allStates[newName] || newName == machine.initial {
+		return fmt.Errorf("rename of state %s to existing state %s", oldName, newName)
+	}
+	rename := func(state string) string {
+		if state == oldName {
+			return newName
+		}
+		return state
+	}
+	renameAll := func(states []string) []string {
+		renamed := make([]string, len(states))
+		for i, state := range states {
+			renamed[i] = rename(state)
+		}
+		return renamed
+	}
+
+	descs := make(map[*EventDesc]bool)
+	states := make(map[stateKey][]*EventDesc, len(machine.states))
+	for key, candidates := range machine.states {
+		states[stateKey{key.event, rename(key.src)}] = candidates
+		for _, desc := range candidates {
+			descs[desc] = true
+		}
+	}
+	machine.states = states
+	for _, desc := range machine.except {
+		descs[desc] = true
+	}
+	for desc := range descs {
+		// Src and SrcExcept may share their arrays with the caller.
+		desc.Src = renameAll(desc.Src)
+		desc.SrcExcept = renameAll(desc.SrcExcept)
+		desc.Dst = rename(desc.Dst)
+	}
+
+	for key, handlers := range machine.handlers {
+		switch key.handlerType {
+		case leaveState, enterState, rollbackState, abortState:
+			if key.target == oldName {
+				renamed := handlerKey{newName, key.handlerType}
+				delete(machine.handlers, key)
+				machine.handlers[renamed] = handlers
+				if prios, ok := machine.priorities[key]; ok {
+					delete(machine.priorities, key)
+					machine.priorities[renamed] = prios
+				}
 			}
-		default:
-			target = handlerName
-			if _, ok := allStates[target]; ok {
-				handlerType = enterState
-			} else if _, ok := allEvents[target]; ok {
-				handlerType = afterEvent
+		}
+	}
+	for key := range machine.invoked {
+		if key.target == oldName && key.handlerType != beforeEvent && key.handlerType != afterEvent {
+			delete(machine.invoked, key)
+			machine.invoked[handlerKey{newName, key.handlerType}] = true
+		}
+	}
+	for e := range machine.traversed {
+		if e.src == oldName || e.dst == oldName {
+			delete(machine.traversed, e)
+			machine.traversed[edge{rename(e.src), e.event, rename(e.dst)}] = true
+		}
+	}
+	for _, actions := range []map[string][]func(){machine.entry, machine.exit} {
+		if list, ok := actions[oldName]; ok {
+			delete(actions, oldName)
+			actions[newName] = list
+		}
+	}
+	if timers, ok := machine.delays[oldName]; ok {
+		delete(machine.delays, oldName)
+		machine.delays[newName] = timers
+	}
+	if count, ok := machine.enterCount[oldName]; ok {
+		delete(machine.enterCount, oldName)
+		machine.enterCount[newName] = count
+	}
+
+	delete(machine.allStates, oldName)
+	machine.allStates[newName] = true
+	machine.initial = rename(machine.initial)
+	if event := machine.pending; event != nil {
+		event.Src = rename(event.Src)
+		event.Dst = rename(event.Dst)
+	}
+	// The machine is still in the same state under its new name, so the
+	// timers of AfterDelay are renamed with it and entries is not counted.
+	machine.stateMu.Lock()
+	for _, timer := range machine.delays[newName] {
+		timer.state = newName
+	}
+	machine.current = rename(machine.current)
+	machine.stateMu.Unlock()
+	machine.stateCond.Broadcast()
+	return nil
+}
+
+// expandExcept adds the transitions declared with SrcExcept or SrcPattern that
+// apply to state. Transitions that list state in Src take precedence, so the
+// event is left alone if one exists.
+func (machine *StateMachine) expandExcept(state string) {
+	for _, desc := range machine.except {
+		excluded := desc.SrcPattern != nil && !desc.SrcPattern.MatchString(state)
+		for _, except := range desc.SrcExcept {
+			if except == state {
+				excluded = true
+				break
 			}
 		}
+		if excluded {
+			continue
+		}
 
-		if handlerType != noHandler {
-			machine.handlers[handlerKey{target, handlerType}] = handler
+		key := stateKey{desc.Name, state}
+		if candidates := machine.states[key]; len(candidates) > 0 && len(candidates[0].Src) > 0 {
+			continue
 		}
+		machine.states[key] = insertCandidate(machine.states[key], desc)
 	}
+}
 
-	return &machine
+// candidates returns the transitions of event from state. States can be
+// nested by separating their names with dots: if state has no transitions for
+// event, those of its parent are used, so that "active.running" accepts the
+// events of "active", and so on up the chain. Names without dots are flat.
+func (machine *StateMachine) candidates(event, state string) ([]*EventDesc, bool) {
+	src, ok := machine.source(event, state)
+	return machine.states[stateKey{event, src}], ok
+}
+
+// source returns the state whose transitions of event apply to state, that is
+// state itself or its nearest ancestor that has transitions of event, as
+// described for candidates.
+func (machine *StateMachine) source(event, state string) (string, bool) {
+	for {
+		if _, ok := machine.states[stateKey{event, state}]; ok {
+			return state, true
+		}
+		i := strings.LastIndexByte(state, '.')
+		if i < 0 {
+			return "", false
+		}
+		state = state[:i]
+	}
+}
+
+// ancestors returns the parents of a nested state, nearest first, such as
+// "active.running" and "active" for "active.running.fast".
+func ancestors(state string) []string {
+	var parents []string
+	for i := strings.LastIndexByte(state, '.'); i >= 0; i = strings.LastIndexByte(state, '.') {
+		state = state[:i]
+		parents = append(parents, state)
+	}
+	return parents
 }
 
-// Current returns the current state of the FSM.
+// insertCandidate adds desc to the candidates of an event and source, keeping
+// them ordered by descending Priority and then by declaration order.
+func insertCandidate(candidates []*EventDesc, desc *EventDesc) []*EventDesc {
+	i := len(candidates)
+	for i > 0 && candidates[i-1].Priority < desc.Priority {
+		i--
+	}
+	candidates = append(candidates, nil)
+	copy(candidates[i+1:], candidates[i:])
+	candidates[i] = desc
+	return candidates
+}
+
+// String returns a short summary of the machine for debugging, such as
+// "StateMachine(current=green, events=5, states=3, pending=false)". events
+// counts the declared transitions and pending reports whether an asynchronous
+// startState is waiting for Excute.
+func (machine *StateMachine) String() string {
+	transitions := 0
+	for _, candidates := range machine.states {
+		transitions += len(candidates)
+	}
+	machine.stateMu.RLock()
+	current, pending := machine.current, machine.startState != nil
+	machine.stateMu.RUnlock()
+
+	buf := make([]byte, 0, 64)
+	buf = append(buf, "StateMachine(current="...)
+	buf = append(buf, current...)
+	buf = append(buf, ", events="...)
+	buf = strconv.AppendInt(buf, int64(transitions), 10)
+	buf = append(buf, ", states="...)
+	buf = strconv.AppendInt(buf, int64(len(machine.allStates)), 10)
+	buf = append(buf, ", pending="...)
+	buf = strconv.AppendBool(buf, pending)
+	buf = append(buf, ')')
+	return string(buf)
+}
+
+// Current returns the current state of the FSM. It is safe to call while
+// another goroutine fires events.
 func (machine *StateMachine) Current() string {
+	machine.stateMu.RLock()
+	defer machine.stateMu.RUnlock()
 	return machine.current
 }
 
-// Is returns true if state is the current state.
+// Is returns true if state is the current state. It is safe to call while
+// another goroutine fires events.
 func (machine *StateMachine) Is(state string) bool {
+	machine.stateMu.RLock()
+	defer machine.stateMu.RUnlock()
 	return state == machine.current
 }
 
+// StateData returns the data stored for the current state with
+// Event.SetStateData, or nil if there is none. It is safe to call while
+// another goroutine fires events.
+func (machine *StateMachine) StateData() interface{} {
+	machine.stateMu.RLock()
+	defer machine.stateMu.RUnlock()
+	return machine.stateData
+}
+
+// CurrentWithPending returns the current state together with the state an
+// asynchronous startState waiting for Excute leads to. While such a startState
+// is pending, state is its source, pendingTo its destination and pending
+// true. Otherwise pendingTo is empty and pending false. Like Inspect it is
+// safe to call from another goroutine and reads all three at the same moment.
+func (machine *StateMachine) CurrentWithPending() (state string, pendingTo string, pending bool) {
+	machine.stateMu.RLock()
+	defer machine.stateMu.RUnlock()
+	if machine.startState != nil && machine.pending != nil {
+		return machine.current, machine.pending.Dst, true
+	}
+	return machine.current, "", false
+}
+
+// setCurrent changes the current state and wakes any goroutines waiting for
+// a state.
+func (machine *StateMachine) setCurrent(state string) {
+	machine.stateMu.Lock()
+	if machine.current != state {
+		machine.current = state
+		machine.entries++
+	}
+	machine.stateMu.Unlock()
+	machine.stateCond.Broadcast()
+}
+
+// setStateData replaces the data of the current state under stateMu, so that
+// StateData can be called from other goroutines.
+func (machine *StateMachine) setStateData(v interface{}) {
+	machine.stateMu.Lock()
+	machine.stateData = v
+	machine.stateMu.Unlock()
+}
+
+// commitCurrent changes the current state for a committed transition and
+// counts it, both under stateMu so that Inspect sees them together.
+func (machine *StateMachine) commitCurrent(state string) {
+	machine.stateMu.Lock()
+	machine.current = state
+	machine.transitionCount++
+	machine.entries++
+	machine.stateMu.Unlock()
+	machine.stateCond.Broadcast()
+}
+
+// setPending makes event the pending startState, or clears the pending
+// startState if event is nil. It takes stateMu so that Inspect sees the
+// change together with the current state.
+func (machine *StateMachine) setPending(event *Event) {
+	var startState func()
+	if event != nil {
+		startState = machine.commit(event)
+	}
+	machine.stateMu.Lock()
+	machine.pending = event
+	machine.startState = startState
+	machine.stateMu.Unlock()
+}
+
 // Can returns true if event can occur in the current state.
 func (machine *StateMachine) Can(event string) bool {
-	_, ok := machine.states[stateKey{event, machine.current}]
+	_, ok := machine.candidates(machine.canonical(event), machine.current)
 	return ok && (machine.startState == nil)
 }
 
+// CanGuarded is like Can but also evaluates the guards of the event with args,
+// returning false if every guard would reject it.
+func (machine *StateMachine) CanGuarded(event string, args ...interface{}) bool {
+	_, err := machine.DryRun(event, args...)
+	return err == nil
+}
+
+// DryRun returns the state firing event with args would lead to, evaluating
+// the guards, or the error Event would return before running any handler. It
+// never changes the machine or runs handlers, so errors that handlers cause
+// are not detected. Macros are reported as an UnknownEventError.
+func (machine *StateMachine) DryRun(eventName string, args ...interface{}) (string, error) {
+	eventName = machine.canonical(eventName)
+	if machine.startState != nil {
+		return "", &InTransitionError{eventName}
+	}
+	if machine.debounced(eventName) {
+		return "", &DebouncedError{eventName}
+	}
+
+	src := machine.Current()
+	candidates, ok := machine.candidates(eventName, src)
+	if !ok {
+		if machine.allEvents[eventName] {
+			return "", &InvalidEventError{eventName, src}
+		}
+		return "", &UnknownEventError{eventName}
+	}
+
+	event := &Event{StateMachine: machine, Name: eventName, Src: src, Args: args}
+	desc, dst, err := machine.selectTransition(event, candidates)
+	if err != nil {
+		return "", err
+	}
+	if desc == nil {
+		return "", &GuardRejectedError{eventName, src}
+	}
+	return dst, nil
+}
+
+// CanFrom returns true if event is defined for state, as if the machine were
+// in it. Unlike Can it ignores a pending startState, and it never changes the
+// machine.
+func (machine *StateMachine) CanFrom(state, event string) bool {
+	_, ok := machine.candidates(machine.canonical(event), state)
+	return ok
+}
+
+// DestinationFrom returns the state event leads to from state, as if the
+// machine were in it, and true, or false if event is not defined for state.
+// Guards are not evaluated, so with several guarded transitions the first one
+// declared is taken. For a transition with a DstFunc or DstFromSrc the Dst
+// field is returned, which may be empty.
+func (machine *StateMachine) DestinationFrom(state, event string) (string, bool) {
+	candidates, ok := machine.candidates(machine.canonical(event), state)
+	if !ok {
+		return "", false
+	}
+	return candidates[0].Dst, true
+}
+
+// CanAll returns true if every one of events can occur in the current state.
+func (machine *StateMachine) CanAll(events ...string) bool {
+	for _, event := range events {
+		if !machine.Can(event) {
+			return false
+		}
+	}
+	return true
+}
+
+// CanAny returns true if at least one of events can occur in the current
+// state.
+func (machine *StateMachine) CanAny(events ...string) bool {
+	for _, event := range events {
+		if machine.Can(event) {
+			return true
+		}
+	}
+	return false
+}
+
+// EventExists returns true if event is defined for any state, regardless of
+// the current one. Unlike Can it tells an event that is inappropriate in the
+// current state apart from one that does not exist. Macros are not events.
+func (machine *StateMachine) EventExists(event string) bool {
+	return machine.allEvents[event]
+}
+
 // Can returns true if event can not occure in the current state.
 // It is a convenience method to help code read nicely.
 func (machine *StateMachine) Cannot(event string) bool {
 	return !machine.Can(event)
 }
 
+// SetMetadata stores val under key. Metadata is shared by all handlers of the
+// machine, which can reach it through Event.StateMachine, and is safe to use
+// from several goroutines.
+func (machine *StateMachine) SetMetadata(key string, val interface{}) {
+	machine.metadataMu.Lock()
+	defer machine.metadataMu.Unlock()
+	machine.metadata[key] = val
+}
+
+// GetMetadata returns the value stored under key and whether it was set.
+func (machine *StateMachine) GetMetadata(key string) (interface{}, bool) {
+	machine.metadataMu.RLock()
+	defer machine.metadataMu.RUnlock()
+	val, ok := machine.metadata[key]
+	return val, ok
+}
+
+// AvailableTransitions returns the sorted names of the events that are
+// defined for the current state.
+func (machine *StateMachine) AvailableTransitions() []string {
+	return machine.AvailableTransitionsFrom(machine.Current())
+}
+
+// AvailableTransitionsFrom returns the sorted names of the events that are
+// defined for state, including those inherited from its parents.
+func (machine *StateMachine) AvailableTransitionsFrom(state string) []string {
+	var events []string
+	for key := range machine.states {
+		if _, ok := machine.candidates(key.event, state); ok && !contains(events, key.event) {
+			events = append(events, key.event)
+		}
+	}
+	sort.Strings(events)
+	return events
+}
+
+// contains returns true if s is one of list.
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// IsFinal returns true if no event is defined for the current state.
+func (machine *StateMachine) IsFinal() bool {
+	return len(machine.AvailableTransitionsFrom(machine.Current())) == 0
+}
+
+// FinalStates returns the sorted known states that have no outgoing
+// transitions, counting those inherited from parent states.
+func (machine *StateMachine) FinalStates() []string {
+	var states []string
+	for state := range machine.allStates {
+		if len(machine.AvailableTransitionsFrom(state)) == 0 {
+			states = append(states, state)
+		}
+	}
+	sort.Strings(states)
+	return states
+}
+
 // Event initiates a state startState with the named event.
 //
 // The call takes a variable number of arguments that will be passed to the
@@ -150,116 +760,732 @@ func (machine *StateMachine) Cannot(event string) bool {
 //
 // It will return nil if the state change is ok or one of these errors:
 //
-// - event X inappropriate because previous startState did not complete
+// - InTransitionError: event X inappropriate because previous startState did
+// not complete
+//
+// - InvalidEventError: event X inappropriate in current state Y
+//
+// - GuardRejectedError: event X rejected by guards in current state Y
 //
-// - event X inappropriate in current state Y
+// - UnknownEventError: event X does not exist, unless OnUnknownEvent is set
 //
-// - event X does not exist
+// - GuardViolationError: a guard tried to change the event
 //
-// - internal error on state startState
+// - LateCancelError: an enter_ or after_ handler cancelled the event
+//
+// - DebouncedError: the event was fired again within its Debounce window
+//
+// - InternalError: internal error on state startState
 //
 // The last error should never occur in this situation and is a sign of an
 // internal bug.
 func (machine *StateMachine) Event(eventName string, args ...interface{}) error {
-	if machine.startState != nil {
-		return fmt.Errorf("event %s inappropriate because previous startState did not complete", eventName)
+	return machine.fire(&Event{Name: eventName, Args: args})
+}
+
+// TryEvent is like Event but suited to polling loops. It reports whether the
+// current state changed, and treats an event that is inappropriate in the
+// current state as a skipped no-op rather than an error. Other errors, such
+// as an UnknownEventError, are still returned.
+func (machine *StateMachine) TryEvent(eventName string, args ...interface{}) (bool, error) {
+	before := machine.Current()
+	err := machine.Event(eventName, args...)
+	var invalid *InvalidEventError
+	if errors.As(err, &invalid) {
+		return false, nil
 	}
+	return machine.Current() != before, err
+}
 
-	dst, ok := machine.states[stateKey{eventName, machine.current}]
-	if !ok {
-		found := false
-		for state, _ := range machine.states {
-			if state.event == eventName {
-				found = true
-				break
+// Transition is like Event but also returns the state the machine is in
+// afterwards: the destination if the transition was committed, and otherwise
+// the state it stayed in, such as when the event was cancelled, failed or
+// left an asynchronous startState waiting for Excute.
+func (machine *StateMachine) Transition(event string, args ...interface{}) (newState string, err error) {
+	err = machine.Event(event, args...)
+	return machine.Current(), err
+}
+
+// EventIfExists is like Event for events that may not be defined, for example
+// when event names come from plugins. If no event or macro named event is
+// defined it does nothing and returns false and a nil error. Otherwise it
+// fires the event and returns true with the error of Event, such as an
+// InvalidEventError or an InTransitionError.
+func (machine *StateMachine) EventIfExists(event string, args ...interface{}) (fired bool, err error) {
+	name := machine.canonical(event)
+	if _, ok := machine.macros[name]; !ok && !machine.allEvents[name] {
+		return false, nil
+	}
+	return true, machine.Event(event, args...)
+}
+
+// EventAsync is like Event but commits the transition in a new goroutine. The
+// before_ and leave_ handlers run before EventAsync returns; the state change
+// and the enter_ and after_ handlers follow in the goroutine, as if Excute
+// were called from it. The returned channel receives what Event would have
+// returned once the transition is over.
+//
+// If a leave_ handler calls Async the transition waits for Excute as usual,
+// and the channel receives the result when Excute completes it. An error
+// that stops the event before the leave_ handlers are done is returned
+// directly, and so is an error of an event queued meanwhile.
+//
+// The machine must not be used until the channel has received, since the
+// goroutine owns it until then.
+func (machine *StateMachine) EventAsync(eventName string, args ...interface{}) (<-chan error, error) {
+	event := &Event{Name: eventName, Args: args, done: make(chan error, 1)}
+	if err := machine.fire(event); err != nil {
+		return nil, err
+	}
+	return event.done, nil
+}
+
+// EventWith is like Event but passes args by name. Handlers and guards read
+// them from Event.NamedArgs or with Event.NamedArg; Event.Args is empty.
+func (machine *StateMachine) EventWith(eventName string, args map[string]interface{}) error {
+	return machine.fire(&Event{Name: eventName, NamedArgs: args})
+}
+
+// EventWithFlags is like Event but makes flags available to guards and
+// handlers through Event.Flag, so that the same machine can behave
+// differently per call.
+func (machine *StateMachine) EventWithFlags(flags map[string]bool, eventName string, args ...interface{}) error {
+	return machine.fire(&Event{Name: eventName, Args: args, flags: flags})
+}
+
+// EventChain fires events in order without arguments. If one of them fails
+// the machine is put back in the state it was in before the chain, dropping
+// any pending asynchronous startState, and a *StepError naming the failed
+// step is returned.
+//
+// The current state, the history, TransitionCount and EnterCount are rolled
+// back, as if the earlier steps had never been committed. Side effects of
+// handlers that ran for them are the caller's responsibility.
+func (machine *StateMachine) EventChain(events ...string) error {
+	snapshot, count := machine.Current(), machine.TransitionCount()
+	for i, name := range events {
+		if err := machine.Event(name); err != nil {
+			if dropped := machine.pending; dropped != nil {
+				machine.setPending(nil)
+				machine.abandon(dropped, fmt.Errorf("event %s dropped by the rollback of an event chain", dropped.Name))
 			}
+			machine.uncommit(snapshot, count)
+			return &StepError{Step: i, Event: name, Err: err}
 		}
-		if found {
-			return fmt.Errorf("event %s inappropriate in current state %s", eventName, machine.current)
+	}
+	return nil
+}
+
+// uncommit puts the machine back in state, removing the transitions committed
+// since TransitionCount was count from the history and the counters.
+func (machine *StateMachine) uncommit(state string, count uint64) {
+	machine.stateMu.Lock()
+	steps := machine.transitionCount - count
+	machine.transitionCount = count
+	machine.stateMu.Unlock()
+	for ; steps > 0 && len(machine.history) > 0; steps-- {
+		last := len(machine.history) - 1
+		machine.enterCount[machine.history[last].Dst]--
+		machine.history = machine.history[:last]
+	}
+	machine.setCurrent(state)
+}
+
+// fire performs the transition described by the partially filled event, or
+// queues it if the event queue is enabled and a transition is in progress or
+// if a startState is pending under the Queue policy.
+func (machine *StateMachine) fire(event *Event) error {
+	pending := machine.startState != nil
+	if machine.queueEnabled && (machine.firing || pending) || machine.inProgress == Queue && pending {
+		machine.enqueue(event)
+		return nil
+	}
+
+	name, state := event.Name, machine.Current()
+	machine.firing = true
+	err := machine.trigger(event)
+	machine.firing = false
+	machine.reject(name, state, err)
+	machine.finish(event, err)
+
+	if queueErr := machine.drainQueue(); err == nil {
+		err = queueErr
+	}
+	machine.detach()
+	return err
+}
+
+// trigger performs the transition described by the partially filled event.
+func (machine *StateMachine) trigger(event *Event) error {
+	event.Name = machine.canonical(event.Name)
+	eventName := event.Name
+	if machine.startState != nil {
+		if machine.inProgress != CancelPending || machine.committing {
+			return &InTransitionError{eventName}
+		}
+		dropped := machine.pending
+		machine.log("pending transition canceled", dropped)
+		machine.setCurrent(dropped.Src)
+		machine.setPending(nil)
+		machine.abandon(dropped, fmt.Errorf("event %s canceled by event %s", dropped.Name, eventName))
+	}
+	if machine.debounced(eventName) {
+		return &DebouncedError{eventName}
+	}
+
+	// The source is captured once so that every phase agrees on it, even
+	// after the startState closure has moved machine.current to dst.
+	src := machine.current
+
+	candidates, ok := machine.candidates(eventName, src)
+	if !ok {
+		if machine.allEvents[eventName] {
+			return &InvalidEventError{eventName, src}
+		} else if steps, ok := machine.macros[eventName]; ok {
+			return machine.fireMacro(event, steps)
+		} else if machine.onUnknown != nil {
+			return machine.onUnknown(eventName, event.Args)
 		} else {
-			return fmt.Errorf("event %s does not exist", eventName)
+			return &UnknownEventError{eventName}
 		}
 	}
 
-	if machine.current == dst {
-		return nil
+	event.StateMachine = machine
+	event.Src = src
+
+	desc, dst, err := machine.selectTransition(event, candidates)
+	if err != nil {
+		return err
+	}
+	if desc == nil {
+		return &GuardRejectedError{eventName, src}
 	}
+	event.Dst = dst
+	event.desc = desc
 
-	event := &Event{machine, eventName, machine.current, dst, nil, args, false, false}
+	if src == dst {
+		machine.runActions(machine.exit[src])
+		machine.runActions(machine.entry[dst])
+		if !machine.selfHandlers {
+			return nil
+		}
+		machine.callPhase(dst, enterState, event)
+		machine.callPhase(eventName, afterEvent, event)
+		return event.Err
+	}
 
-	// Call the before_ handlers, first the named then the general version.
-	if handler, ok := machine.handlers[handlerKey{eventName, beforeEvent}]; ok {
-		handler(event)
-		if event.canceled {
-			return event.Err
+	next := func() error {
+		return machine.perform(event)
+	}
+	for i := len(machine.middleware) - 1; i >= 0; i-- {
+		mw, inner := machine.middleware[i], next
+		next = func() error {
+			return mw(inner, event)
 		}
 	}
-	if handler, ok := machine.handlers[handlerKey{"", beforeEvent}]; ok {
-		handler(event)
-		if event.canceled {
-			return event.Err
+	return next()
+}
+
+// perform runs the handlers of event and commits it, unless it is cancelled
+// or goes asynchronous.
+func (machine *StateMachine) perform(event *Event) error {
+	eventName, src := event.Name, event.Src
+
+	// Call the before_ handlers, by default first the named then the general
+	// version.
+	machine.callPhase(eventName, beforeEvent, event)
+	if !event.canceled && event.desc.Before != nil {
+		if err := event.desc.Before(event); err != nil {
+			hadErr := event.Err != nil
+			event.CancelWithError(err)
+			if !hadErr {
+				machine.callHandler(handlerKey{"", onError}, event)
+			}
 		}
 	}
+	if event.canceled {
+		return event.Err
+	}
+
+	machine.setPending(event)
+
+	// Call the leave_ handlers.
+	machine.callPhase(src, leaveState, event)
+	if event.canceled {
+		machine.setPending(nil)
+		return event.Err
+	} else if event.async || event.done != nil {
+		// Events fired with EventAsync are completed by detach.
+		return event.Err
+	}
 
-	machine.startState = func() {
-		// Do the state startState.
-		machine.current = dst
+	// Perform the rest of the startState, if not asynchronous.
+	err := machine.Excute()
+	if err != nil {
+		return &InternalError{}
+	}
+
+	return event.Err
+}
 
-		// Call the enter_ handlers, first the named then the general version.
-		if handler, ok := machine.handlers[handlerKey{machine.current, enterState}]; ok {
-			handler(event)
+// OnReject sets fn to be called whenever firing an event fails, with the name
+// of the event, the state the machine was in and the error, for example to
+// count invalid user actions. Events that fail after being queued are
+// reported too.
+func (machine *StateMachine) OnReject(fn func(event, state string, err error)) {
+	machine.onReject = fn
+}
+
+// reject reports err to the OnReject callback if both are set.
+func (machine *StateMachine) reject(event, state string, err error) {
+	if err != nil && machine.onReject != nil {
+		machine.onReject(event, state, err)
+	}
+}
+
+// finish sends err to the channel of an event fired with EventAsync if the
+// event is over, that is if no startState of it is pending.
+func (machine *StateMachine) finish(event *Event, err error) {
+	if event.done != nil && machine.pending != event {
+		event.done <- err
+	}
+}
+
+// abandon sends err to the channel of an event fired with EventAsync whose
+// pending startState has been dropped.
+func (machine *StateMachine) abandon(event *Event, err error) {
+	if event.done != nil {
+		event.done <- err
+	}
+}
+
+// detach hands the pending startState of an event fired with EventAsync to
+// a new goroutine, unless a leave_ handler made it wait for Excute.
+func (machine *StateMachine) detach() {
+	event := machine.pending
+	if event == nil || event.done == nil || event.async || event.detached {
+		return
+	}
+	event.detached = true
+	go machine.Excute()
+}
+
+// Middleware wraps the handling of every event with cross-cutting behaviour
+// such as timing or tracing. It is called with the event after its
+// destination has been selected and must call next to run the handlers and
+// commit the transition, returning its error. Not calling next aborts the
+// transition; Event then returns the error of the middleware.
+type Middleware func(next func() error, e *Event) error
+
+// Use adds mw to the middleware of the machine. Middleware runs in the order
+// it was added, the first added being the outermost.
+func (machine *StateMachine) Use(mw Middleware) {
+	machine.middleware = append(machine.middleware, mw)
+}
+
+// EnableEventQueue makes events fired while a transition is in progress, such
+// as from a handler or while an asynchronous startState is pending, wait in a
+// queue instead of failing. Queued events are fired in order once the
+// transition in progress has been committed. The Event call that queues an
+// event returns nil; an error from a queued event is returned by the Event or
+// Excute call that committed the transition in progress, unless that call
+// already returns an error of its own.
+func (machine *StateMachine) EnableEventQueue() {
+	machine.queueEnabled = true
+}
+
+// OnUnknownEvent sets fn to handle events that are not defined, instead of
+// failing with an UnknownEventError, for example to log them in one place.
+// fn is called with the name and arguments of the event, and Event returns
+// its error, so returning nil ignores the event. EventIfExists never calls
+// fn.
+func (machine *StateMachine) OnUnknownEvent(fn func(event string, args []interface{}) error) {
+	machine.onUnknown = fn
+}
+
+// OnQueue sets fn to be called whenever an event is queued by the event queue,
+// the Queue policy or Event.Goto instead of being fired, with the name of the
+// event and the number of events waiting in the queue including it. A depth
+// that keeps growing points to handlers that fire each other in a loop.
+func (machine *StateMachine) OnQueue(fn func(event string, depth int)) {
+	machine.onQueue = fn
+}
+
+// enqueue adds event to the queue and reports the new depth to the OnQueue
+// callback.
+func (machine *StateMachine) enqueue(event *Event) {
+	machine.queue = append(machine.queue, event)
+	if machine.onQueue != nil {
+		machine.onQueue(event.Name, len(machine.queue))
+	}
+}
+
+// drainQueue fires queued events in order until the queue is empty or an
+// asynchronous startState is pending. It returns the first error.
+func (machine *StateMachine) drainQueue() error {
+	var first error
+	for len(machine.queue) > 0 && machine.startState == nil {
+		event := machine.queue[0]
+		machine.queue = machine.queue[1:]
+
+		if event.depth > machine.maxChainDepth {
+			if first == nil {
+				first = fmt.Errorf("event %s exceeds the maximum chain depth of %d", event.Name, machine.maxChainDepth)
+			}
+			continue
 		}
-		if handler, ok := machine.handlers[handlerKey{"", enterState}]; ok {
-			handler(event)
+
+		name, state := event.Name, machine.Current()
+		machine.firing = true
+		err := machine.trigger(event)
+		machine.firing = false
+		machine.reject(name, state, err)
+		machine.finish(event, err)
+
+		if first == nil {
+			first = err
 		}
+	}
+	return first
+}
 
-		// Call the after_ handlers, first the named then the general version.
-		if handler, ok := machine.handlers[handlerKey{eventName, afterEvent}]; ok {
-			handler(event)
+// Alias makes alias a synonym of event. Firing alias behaves exactly like
+// firing event: the event passed to handlers has the name of event and the
+// handlers registered for event run. An alias cannot have the name of an
+// existing event and must refer to one.
+func (machine *StateMachine) Alias(alias, event string) error {
+	if machine.allEvents[alias] {
+		return fmt.Errorf("alias %s shadows an existing event", alias)
+	}
+	if !machine.allEvents[event] {
+		return fmt.Errorf("alias %s refers to unknown event %s", alias, event)
+	}
+	machine.aliases[alias] = event
+	return nil
+}
+
+// canonical returns the event an alias refers to, or name if it is not an
+// alias.
+func (machine *StateMachine) canonical(name string) string {
+	if event, ok := machine.aliases[name]; ok {
+		return event
+	}
+	return name
+}
+
+// DefineMacro defines a named sequence of events. Firing the macro with Event
+// fires each of the events in order with the same arguments, stopping at the
+// first one that fails, in which case a *StepError is returned. Transitions
+// made by earlier steps are kept.
+//
+// A macro is only used if no event of the same name exists.
+func (machine *StateMachine) DefineMacro(name string, events []string) {
+	steps := make([]string, len(events))
+	copy(steps, events)
+	machine.macros[name] = steps
+}
+
+// fireMacro fires each step of the macro described by event.
+func (machine *StateMachine) fireMacro(event *Event, steps []string) error {
+	for i, step := range steps {
+		err := machine.trigger(&Event{Name: step, Args: event.Args, NamedArgs: event.NamedArgs, flags: event.flags})
+		if err != nil {
+			return &StepError{Macro: event.Name, Step: i, Event: step, Err: err}
 		}
-		if handler, ok := machine.handlers[handlerKey{"", afterEvent}]; ok {
-			handler(event)
+	}
+	return nil
+}
+
+// callPhase runs the named handlers for target and the general handlers of
+// the given type in the configured HandlerOrder. Cancelling the event stops
+// the before_ and leave_ phases early, and the enter_ phase with
+// WithEnterRollback, as does going asynchronous in the leave_ phase.
+func (machine *StateMachine) callPhase(target string, handlerType handlerType, event *Event) {
+	keys := [2]handlerKey{{target, handlerType}, {"", handlerType}}
+	if machine.handlerOrder == GenericFirst {
+		keys[0], keys[1] = keys[1], keys[0]
+	}
+
+	machine.log("running handlers", event, slog.String("phase", handlerType.String()))
+	for _, key := range keys {
+		for _, handler := range machine.handlers[key] {
+			machine.runHandler(key, handler, event)
+			if machine.phaseStopped(handlerType, event) {
+				return
+			}
 		}
 	}
+}
 
-	// Call the leave_ handlers, first the named then the general version.
-	if handler, ok := machine.handlers[handlerKey{machine.current, leaveState}]; ok {
-		handler(event)
+// phaseStopped returns true if the remaining handlers of the phase must be
+// skipped, logging a cancellation.
+func (machine *StateMachine) phaseStopped(handlerType handlerType, event *Event) bool {
+	switch handlerType {
+	case beforeEvent:
 		if event.canceled {
-			machine.startState = nil
-			return event.Err
-		} else if event.async {
-			return event.Err
+			machine.log("transition canceled", event, slog.String("phase", handlerType.String()))
+			return true
 		}
+	case enterState:
+		if event.canceled && machine.enterRollback {
+			machine.log("transition canceled", event, slog.String("phase", handlerType.String()))
+			return true
+		}
+	case leaveState:
+		if event.canceled {
+			machine.log("transition canceled", event, slog.String("phase", handlerType.String()))
+			return true
+		}
+		return event.async
+	}
+	return false
+}
+
+// SetLogger makes the machine log every phase, cancellation and committed
+// transition to l at debug level, with the event name and the source and
+// destination states as the attributes "event", "src" and "dst". Passing nil
+// turns logging off, which is the default.
+func (machine *StateMachine) SetLogger(l *slog.Logger) {
+	machine.logger = l
+}
+
+// log writes a debug record about event if a logger is set.
+func (machine *StateMachine) log(msg string, event *Event, attrs ...slog.Attr) {
+	if machine.logger == nil {
+		return
+	}
+	attrs = append(attrs,
+		slog.String("event", event.Name),
+		slog.String("src", event.Src),
+		slog.String("dst", event.Dst),
+	)
+	machine.logger.LogAttrs(context.Background(), slog.LevelDebug, msg, attrs...)
+}
+
+// callHandler runs the handlers registered for key, if any.
+func (machine *StateMachine) callHandler(key handlerKey, event *Event) {
+	for _, handler := range machine.handlers[key] {
+		machine.runHandler(key, handler, event)
 	}
-	if handler, ok := machine.handlers[handlerKey{"", leaveState}]; ok {
-		handler(event)
+}
+
+// runHandler runs handler, which is registered for key, and the error
+// handlers if it sets an error.
+func (machine *StateMachine) runHandler(key handlerKey, handler Handler, event *Event) {
+	machine.invoked[key] = true
+	if machine.recorder != nil {
+		machine.recorder.invocations = append(machine.recorder.invocations, key.String())
+	}
+	hadErr := event.Err != nil
+	handler(event)
+	if !hadErr && event.Err != nil && key.handlerType != onError {
+		machine.callHandler(handlerKey{"", onError}, event)
+	}
+}
+
+// commit returns the startState closure that moves the machine to the
+// destination of event and calls the enter_, after_, transition and final
+// handlers.
+func (machine *StateMachine) commit(event *Event) func() {
+	eventName, src := event.Name, event.Src
+	return func() {
+		// A handler may have redirected the event since dst was selected.
+		dst := event.Dst
+
+		// Do the state startState. Data owned by the state being left
+		// goes with it.
+		machine.setStateData(nil)
+		machine.runActions(machine.exit[src])
+		machine.commitCurrent(dst)
+		machine.runActions(machine.entry[dst])
+		now := machine.clock.Now()
+		transition := Transition{eventName, src, dst, now}
+		machine.record(transition)
+		machine.enterCount[dst]++
+		declared, _ := machine.source(eventName, src)
+		machine.traversed[edge{declared, eventName, dst}] = true
+		machine.log("transition committed", event)
+
+		if event.desc != nil && event.desc.Action != nil {
+			event.desc.Action(event)
+		}
+
+		// Call the enter_ and after_ handlers.
+		machine.callPhase(dst, enterState, event)
+		if event.canceled && machine.enterRollback {
+			machine.rollback(event)
+			return
+		}
+		// A transition undone by the rollback does not start a Debounce
+		// window.
+		machine.lastCommitted[eventName] = now
+		machine.notify(transition)
+		machine.callPhase(eventName, afterEvent, event)
 		if event.canceled {
-			machine.startState = nil
-			return event.Err
-		} else if event.async {
-			return event.Err
+			event.Err = &LateCancelError{eventName, dst, event.Err}
+		}
+		machine.callHandler(handlerKey{"", onTransition}, event)
+		final := handlerKey{"", enterFinal}
+		if len(machine.handlers[final]) > 0 && len(machine.AvailableTransitionsFrom(dst)) == 0 {
+			machine.callHandler(final, event)
 		}
 	}
+}
 
-	// Perform the rest of the startState, if not asynchronous.
-	err := machine.Excute()
-	if err != nil {
-		return fmt.Errorf("internal error on state startState")
+// rollback undoes the committed transition of event after an enter_ handler
+// cancelled it, see WithEnterRollback.
+func (machine *StateMachine) rollback(event *Event) {
+	src, dst := event.Src, event.Dst
+	machine.callHandler(handlerKey{dst, rollbackState}, event)
+	machine.runActions(machine.exit[dst])
+
+	machine.stateMu.Lock()
+	machine.current = src
+	machine.transitionCount--
+	machine.entries++
+	machine.stateMu.Unlock()
+	machine.stateCond.Broadcast()
+	machine.history = machine.history[:len(machine.history)-1]
+	machine.enterCount[dst]--
+
+	machine.runActions(machine.entry[src])
+	machine.log("transition rolled back", event)
+}
+
+// ExecuteWith completes an asynchronous state change like Excute, passing
+// args to the resumed event. If the transition has a DstFunc, it is called
+// again with args, which replace the arguments of the event, and the
+// transition ends in the state it returns. Otherwise args are appended to the
+// arguments of the event and the destination is unchanged.
+//
+// If the new destination is not a known state an error is returned and the
+// state change stays pending.
+func (machine *StateMachine) ExecuteWith(args ...interface{}) error {
+	event := machine.pending
+	if machine.committing {
+		return &ReentrantExecuteError{}
+	}
+	if machine.startState == nil || event == nil {
+		return &NotInTransitionError{}
 	}
 
-	return event.Err
+	if event.desc != nil && event.desc.DstFunc != nil {
+		dst, err := machine.destination(event.desc, event.Src, args)
+		if err != nil {
+			return err
+		}
+		event.Args = args
+		event.Dst = dst
+	} else {
+		event.Args = append(event.Args, args...)
+	}
+	return machine.Excute()
+}
+
+// selectTransition returns the first candidate whose guard passes together
+// with its destination, or nil if every guard rejects the event. With
+// EnableWeighted the first guardless candidate stands for a random choice
+// among all guardless candidates.
+func (machine *StateMachine) selectTransition(event *Event, candidates []*EventDesc) (*EventDesc, string, error) {
+	for i, desc := range candidates {
+		if desc.Guard == nil && machine.random != nil {
+			desc = machine.pickWeighted(candidates[i:])
+		}
+		dst, err := machine.destination(desc, event.Src, event.Args)
+		if err != nil {
+			return nil, "", err
+		}
+		if desc.Guard == nil {
+			return desc, dst, nil
+		}
+		guard := newGuardEvent(event, dst)
+		ok := desc.Guard(&guard.Event)
+		if err := guard.err(); err != nil {
+			return nil, "", err
+		}
+		if ok {
+			return desc, dst, nil
+		}
+	}
+	return nil, "", nil
+}
+
+// destination returns the state the transition described by desc leads to
+// when fired from src with args.
+func (machine *StateMachine) destination(desc *EventDesc, src string, args []interface{}) (string, error) {
+	var dst string
+	switch {
+	case desc.DstFunc != nil:
+		dst = desc.DstFunc(args)
+	case desc.DstFromSrc != nil:
+		dst = desc.DstFromSrc(src)
+	default:
+		return desc.Dst, nil
+	}
+	if !machine.allStates[dst] {
+		return "", fmt.Errorf("event %s: destination %s is not a known state", desc.Name, dst)
+	}
+	return dst, nil
+}
+
+// AbortAsync discards an asynchronous startState that is waiting for Excute,
+// so that the machine stays in the source state and accepts events again.
+// The abort_<STATE> handler of the source state is called with the event of
+// the discarded startState; its enter_ and after_ handlers are never called.
+// An event fired with EventAsync receives an error on its channel.
+//
+// AbortAsync returns a NotInTransitionError if no startState is pending and
+// a ReentrantExecuteError if it is called while one is being committed.
+func (machine *StateMachine) AbortAsync() error {
+	if machine.committing {
+		return &ReentrantExecuteError{}
+	}
+	event := machine.pending
+	if machine.startState == nil || event == nil {
+		return &NotInTransitionError{}
+	}
+
+	machine.setPending(nil)
+	machine.setCurrent(event.Src)
+	machine.log("pending transition aborted", event)
+	machine.callHandler(handlerKey{event.Src, abortState}, event)
+	machine.abandon(event, fmt.Errorf("event %s aborted", event.Name))
+	return machine.drainQueue()
 }
 
 // Excute completes an asynchrounous state change.
 //
 // The callback for leave_<STATE> must prviously have called Async on its
-// event to have initiated an asynchronous state startState.
+// event to have initiated an asynchronous state startState, otherwise a
+// NotInTransitionError is returned. Calling it from an enter_ or after_
+// handler while the startState is being committed returns a
+// ReentrantExecuteError.
 func (f *StateMachine) Excute() error {
+	if f.committing {
+		return &ReentrantExecuteError{}
+	}
 	if f.startState == nil {
-		return fmt.Errorf("startState inappropriate because no state change in progress")
+		return &NotInTransitionError{}
 	}
+
+	event := f.pending
+	firing := f.firing
+	f.firing = true
+	f.committing = true
 	f.startState()
-	f.startState = nil
-	return nil
+	f.committing = false
+	f.setPending(nil)
+	f.firing = firing
+
+	var err error
+	if !firing {
+		err = f.drainQueue()
+		f.detach()
+	}
+	// The channel of an event fired with EventAsync hands the machine back
+	// to its caller, so it receives only once the machine is left alone.
+	if event != nil && event.done != nil {
+		event.done <- event.Err
+	}
+	return err
 }
@@ -2,6 +2,9 @@ package statemachine
 
 import (
 	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
 	"testing"
 )
 
@@ -418,3 +421,1703 @@ func TestHandlerArgs(t *testing.T) {
 	)
 	fsm.Event("run", "test")
 }
+
+func TestCancelWithErrorBeforeEvent(t *testing.T) {
+	canceled := fmt.Errorf("canceled")
+	fsm := NewStateMachine(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+		},
+		Handlers{
+			"before_run": func(e *Event) {
+				e.CancelWithError(canceled)
+			},
+		},
+	)
+	err := fsm.Event("run")
+	if err != canceled {
+		t.Fatalf("expected canceled error, got %v", err)
+	}
+	if fsm.Current() != "start" {
+		t.FailNow()
+	}
+}
+
+func TestCancelWithErrorLeaveState(t *testing.T) {
+	canceled := fmt.Errorf("canceled")
+	fsm := NewStateMachine(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+		},
+		Handlers{
+			"leave_state": func(e *Event) {
+				e.CancelWithError(canceled)
+			},
+		},
+	)
+	err := fsm.Event("run")
+	if err != canceled {
+		t.Fatalf("expected canceled error, got %v", err)
+	}
+	if fsm.Current() != "start" {
+		t.FailNow()
+	}
+	if fsm.Cannot("run") {
+		t.Fatal("expected run to be possible after a canceled transition")
+	}
+}
+
+func TestLeaveHandlerKeyedOnSource(t *testing.T) {
+	var calls []string
+	fsm := NewStateMachine(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+			{Name: "reset", Src: []string{"end"}, Dst: "start"},
+		},
+		Handlers{
+			"leave_start": func(e *Event) {
+				calls = append(calls, "leave_start:"+e.StateMachine.Current())
+				e.Async()
+			},
+			"leave_end": func(e *Event) {
+				calls = append(calls, "leave_end:"+e.StateMachine.Current())
+			},
+			"enter_end": func(e *Event) {
+				calls = append(calls, "enter_end:"+e.StateMachine.Current())
+			},
+		},
+	)
+
+	fsm.Event("run")
+	fsm.Excute()
+	expected := []string{"leave_start:start", "enter_end:end"}
+	if fmt.Sprint(calls) != fmt.Sprint(expected) {
+		t.Fatalf("expected %v, got %v", expected, calls)
+	}
+
+	calls = nil
+	fsm.Event("reset")
+	expected = []string{"leave_end:end"}
+	if fmt.Sprint(calls) != fmt.Sprint(expected) {
+		t.Fatalf("expected %v, got %v", expected, calls)
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	var userID interface{}
+	fsm := NewStateMachine(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+		},
+		Handlers{
+			"before_event": func(e *Event) {
+				e.StateMachine.SetMetadata("user", 42)
+			},
+			"after_event": func(e *Event) {
+				userID, _ = e.StateMachine.GetMetadata("user")
+			},
+		},
+	)
+	if _, ok := fsm.GetMetadata("user"); ok {
+		t.Fatal("expected no metadata before the event")
+	}
+	fsm.Event("run")
+	if userID != 42 {
+		t.Fatalf("expected 42, got %v", userID)
+	}
+}
+
+func TestMacro(t *testing.T) {
+	fsm := NewStateMachine(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+			{Name: "close", Src: []string{"open"}, Dst: "closed"},
+			{Name: "lock", Src: []string{"closed"}, Dst: "locked"},
+		},
+		Handlers{},
+	)
+	fsm.DefineMacro("open_close", []string{"open", "close"})
+	if err := fsm.Event("open_close"); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.Current() != "closed" {
+		t.Fatalf("expected closed, got %s", fsm.Current())
+	}
+}
+
+func TestMacroStepFails(t *testing.T) {
+	fsm := NewStateMachine(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+			{Name: "close", Src: []string{"open"}, Dst: "closed"},
+			{Name: "lock", Src: []string{"closed"}, Dst: "locked"},
+		},
+		Handlers{},
+	)
+	fsm.DefineMacro("open_lock", []string{"open", "lock"})
+	err := fsm.Event("open_lock")
+	stepErr, ok := err.(*StepError)
+	if !ok {
+		t.Fatalf("expected a step error, got %v", err)
+	}
+	if stepErr.Step != 1 || stepErr.Event != "lock" {
+		t.Fatalf("unexpected failed step %d (%s)", stepErr.Step, stepErr.Event)
+	}
+	if err.Error() != "macro open_lock step 1 (lock): event lock inappropriate in current state open" {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if fsm.Current() != "open" {
+		t.Fatalf("expected open, got %s", fsm.Current())
+	}
+}
+
+func TestRedirect(t *testing.T) {
+	enterEnd := false
+	enterAltEnd := false
+	fsm := NewStateMachine(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+			{Name: "fallback", Src: []string{"start"}, Dst: "alt_end"},
+		},
+		Handlers{
+			"before_run": func(e *Event) {
+				e.Redirect("alt_end")
+			},
+			"enter_end": func(e *Event) {
+				enterEnd = true
+			},
+			"enter_alt_end": func(e *Event) {
+				enterAltEnd = true
+			},
+		},
+	)
+	if err := fsm.Event("run"); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.Current() != "alt_end" {
+		t.Fatalf("expected alt_end, got %s", fsm.Current())
+	}
+	if enterEnd || !enterAltEnd {
+		t.Fatal("expected only the enter handler of the redirected state to run")
+	}
+}
+
+func TestRedirectUnknownState(t *testing.T) {
+	fsm := NewStateMachine(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+		},
+		Handlers{
+			"leave_start": func(e *Event) {
+				e.Redirect("nowhere")
+			},
+		},
+	)
+	err := fsm.Event("run")
+	if err == nil || err.Error() != "event run redirected to unknown state nowhere" {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if fsm.Current() != "start" {
+		t.Fatalf("expected start, got %s", fsm.Current())
+	}
+}
+
+func TestString(t *testing.T) {
+	fsm := NewStateMachine(
+		"green",
+		Events{
+			{Name: "warn", Src: []string{"green"}, Dst: "yellow"},
+			{Name: "panic", Src: []string{"yellow"}, Dst: "red"},
+			{Name: "panic", Src: []string{"green"}, Dst: "red"},
+			{Name: "calm", Src: []string{"red"}, Dst: "yellow"},
+			{Name: "clear", Src: []string{"yellow"}, Dst: "green"},
+		},
+		Handlers{
+			"leave_yellow": func(e *Event) {
+				e.Async()
+			},
+		},
+	)
+	if s := fsm.String(); s != "StateMachine(current=green, events=5, states=3, pending=false)" {
+		t.Fatalf("unexpected string %s", s)
+	}
+	fsm.Event("warn")
+	fsm.Event("panic")
+	if s := fsm.String(); s != "StateMachine(current=yellow, events=5, states=3, pending=true)" {
+		t.Fatalf("unexpected string %s", s)
+	}
+}
+
+func TestEventQueue(t *testing.T) {
+	var order []string
+	fsm := NewStateMachine(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "running"},
+			{Name: "finish", Src: []string{"running"}, Dst: "end"},
+			{Name: "archive", Src: []string{"end"}, Dst: "archived"},
+		},
+		Handlers{
+			"after_run": func(e *Event) {
+				if err := e.StateMachine.Event("finish"); err != nil {
+					t.Fatal(err)
+				}
+				e.StateMachine.Event("archive")
+			},
+			"enter_state": func(e *Event) {
+				order = append(order, e.Dst)
+			},
+		},
+	)
+	fsm.EnableEventQueue()
+
+	if err := fsm.Event("run"); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.Current() != "archived" {
+		t.Fatalf("expected archived, got %s", fsm.Current())
+	}
+	expected := []string{"running", "end", "archived"}
+	if fmt.Sprint(order) != fmt.Sprint(expected) {
+		t.Fatalf("expected %v, got %v", expected, order)
+	}
+}
+
+func TestEventQueueAsync(t *testing.T) {
+	fsm := NewStateMachine(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+			{Name: "reset", Src: []string{"end"}, Dst: "start"},
+		},
+		Handlers{
+			"leave_start": func(e *Event) {
+				e.Async()
+			},
+		},
+	)
+	fsm.EnableEventQueue()
+
+	fsm.Event("run")
+	if err := fsm.Event("reset"); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.Current() != "start" {
+		t.FailNow()
+	}
+	if err := fsm.Excute(); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.Current() != "start" {
+		t.Fatalf("expected the queued reset to return to start, got %s", fsm.Current())
+	}
+	if changes, _ := fsm.ChangesSince(""); len(changes) != 2 {
+		t.Fatalf("expected two transitions, got %v", changes)
+	}
+}
+
+func TestOnQueue(t *testing.T) {
+	fsm := NewStateMachine(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+			{Name: "reset", Src: []string{"end"}, Dst: "start"},
+		},
+		Handlers{
+			"after_run": func(e *Event) {
+				if len(e.Args) == 0 {
+					e.StateMachine.Event("reset")
+					e.StateMachine.Event("run", "again")
+					e.StateMachine.Event("reset")
+				}
+			},
+		},
+	)
+	fsm.EnableEventQueue()
+
+	var queued []string
+	fsm.OnQueue(func(event string, depth int) {
+		queued = append(queued, fmt.Sprintf("%s %d", event, depth))
+	})
+	if err := fsm.Event("run"); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"reset 1", "run 2", "reset 3"}
+	if fmt.Sprint(queued) != fmt.Sprint(expected) {
+		t.Fatalf("expected %v, got %v", expected, queued)
+	}
+}
+
+func TestArg(t *testing.T) {
+	fsm := NewStateMachine(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+		},
+		Handlers{
+			"run": func(e *Event) {
+				if arg, err := e.Arg(0); err != nil || arg != "test" {
+					t.Errorf("unexpected arg %v, %v", arg, err)
+				}
+				if s, err := Arg[string](e, 0); err != nil || s != "test" {
+					t.Errorf("unexpected arg %v, %v", s, err)
+				}
+				if _, err := Arg[int](e, 0); err == nil || err.Error() != "arg 0 is string, want int" {
+					t.Errorf("unexpected error %v", err)
+				}
+				if _, err := Arg[int](e, 1); err == nil || err.Error() != "arg 1 out of range, event run has 1 args" {
+					t.Errorf("unexpected error %v", err)
+				}
+				if _, err := e.Arg(-1); err == nil {
+					t.Error("expected an error for a negative index")
+				}
+			},
+		},
+	)
+	fsm.Event("run", "test")
+}
+
+func TestEventExists(t *testing.T) {
+	fsm := NewStateMachine(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+			{Name: "close", Src: []string{"open"}, Dst: "closed"},
+		},
+		Handlers{},
+	)
+	if !fsm.EventExists("close") || fsm.Can("close") {
+		t.Fatal("expected close to exist but be inappropriate")
+	}
+	if fsm.EventExists("lock") {
+		t.Fatal("expected lock not to exist")
+	}
+}
+
+func TestDstFunc(t *testing.T) {
+	newMachine := func() *StateMachine {
+		fsm := NewStateMachine(
+			"pending",
+			Events{
+				{Name: "review", Src: []string{"pending"}, DstFunc: func(args []interface{}) string {
+					if len(args) > 0 && args[0] == "approve" {
+						return "approved"
+					}
+					return "rejected"
+				}},
+				{Name: "escalate", Src: []string{"pending"}, DstFunc: func(args []interface{}) string {
+					return "escalated"
+				}},
+			},
+			Handlers{},
+		)
+		fsm.AddState("approved")
+		fsm.AddState("rejected")
+		return fsm
+	}
+
+	fsm := newMachine()
+	if err := fsm.Event("review", "approve"); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.Current() != "approved" {
+		t.Fatalf("expected approved, got %s", fsm.Current())
+	}
+
+	fsm = newMachine()
+	if err := fsm.Event("review", "deny"); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.Current() != "rejected" {
+		t.Fatalf("expected rejected, got %s", fsm.Current())
+	}
+
+	fsm = newMachine()
+	err := fsm.Event("escalate")
+	if err == nil || err.Error() != "event escalate: destination escalated is not a known state" {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if fsm.Current() != "pending" {
+		t.Fatalf("expected pending, got %s", fsm.Current())
+	}
+}
+
+func TestFinalStates(t *testing.T) {
+	fsm := NewStateMachine(
+		"green",
+		Events{
+			{Name: "warn", Src: []string{"green"}, Dst: "yellow"},
+			{Name: "panic", Src: []string{"yellow"}, Dst: "red"},
+			{Name: "panic", Src: []string{"green"}, Dst: "red"},
+			{Name: "calm", Src: []string{"red"}, Dst: "yellow"},
+			{Name: "clear", Src: []string{"yellow"}, Dst: "green"},
+		},
+		Handlers{},
+	)
+	if states := fsm.FinalStates(); len(states) != 0 {
+		t.Fatalf("expected no final states, got %v", states)
+	}
+	if fsm.IsFinal() {
+		t.Fatal("expected green not to be final")
+	}
+
+	fsm = NewStateMachine(
+		"draft",
+		Events{
+			{Name: "submit", Src: []string{"draft"}, Dst: "review"},
+			{Name: "publish", Src: []string{"review"}, Dst: "published"},
+		},
+		Handlers{},
+	)
+	if states := fsm.FinalStates(); fmt.Sprint(states) != "[published]" {
+		t.Fatalf("expected [published], got %v", states)
+	}
+	fsm.Event("submit")
+	if fsm.IsFinal() {
+		t.Fatal("expected review not to be final")
+	}
+	fsm.Event("publish")
+	if !fsm.IsFinal() {
+		t.Fatal("expected published to be final")
+	}
+}
+
+func TestEventChain(t *testing.T) {
+	fsm := NewStateMachine(
+		"green",
+		Events{
+			{Name: "warn", Src: []string{"green"}, Dst: "yellow"},
+			{Name: "panic", Src: []string{"yellow"}, Dst: "red"},
+			{Name: "calm", Src: []string{"red"}, Dst: "yellow"},
+			{Name: "clear", Src: []string{"yellow"}, Dst: "green"},
+		},
+		Handlers{},
+	)
+	if err := fsm.EventChain("warn", "panic", "calm"); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.Current() != "yellow" {
+		t.Fatalf("expected yellow, got %s", fsm.Current())
+	}
+}
+
+func TestEventChainRollback(t *testing.T) {
+	fsm := NewStateMachine(
+		"green",
+		Events{
+			{Name: "warn", Src: []string{"green"}, Dst: "yellow"},
+			{Name: "panic", Src: []string{"yellow"}, Dst: "red"},
+			{Name: "calm", Src: []string{"red"}, Dst: "yellow"},
+			{Name: "clear", Src: []string{"yellow"}, Dst: "green"},
+		},
+		Handlers{},
+	)
+	err := fsm.EventChain("warn", "panic", "clear")
+	stepErr, ok := err.(*StepError)
+	if !ok || stepErr.Step != 2 || stepErr.Event != "clear" {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err.Error() != "step 2 (clear): event clear inappropriate in current state red" {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if fsm.Current() != "green" {
+		t.Fatalf("expected green, got %s", fsm.Current())
+	}
+	if changes, _ := fsm.ChangesSince(""); len(changes) != 0 {
+		t.Fatalf("expected no changes, got %v", changes)
+	}
+	if fsm.TransitionCount() != 0 || fsm.EnterCount("yellow") != 0 || fsm.EnterCount("red") != 0 {
+		t.Fatalf("expected no counted transitions, got %d", fsm.TransitionCount())
+	}
+
+	if err := fsm.Event("warn"); err != nil {
+		t.Fatal(err)
+	}
+	changes, _ := fsm.ChangesSince("")
+	if len(changes) != 1 || changes[0].Src != "green" || changes[0].Dst != "yellow" {
+		t.Fatalf("unexpected changes %v", changes)
+	}
+}
+
+func TestCanAllCanAny(t *testing.T) {
+	fsm := NewStateMachine(
+		"green",
+		Events{
+			{Name: "warn", Src: []string{"green"}, Dst: "yellow"},
+			{Name: "panic", Src: []string{"yellow"}, Dst: "red"},
+			{Name: "panic", Src: []string{"green"}, Dst: "red"},
+			{Name: "calm", Src: []string{"red"}, Dst: "yellow"},
+			{Name: "clear", Src: []string{"yellow"}, Dst: "green"},
+		},
+		Handlers{
+			"leave_green": func(e *Event) {
+				e.Async()
+			},
+		},
+	)
+	if !fsm.CanAll("warn", "panic") {
+		t.Fatal("expected warn and panic to be possible")
+	}
+	if fsm.CanAll("warn", "calm") {
+		t.Fatal("expected calm not to be possible")
+	}
+	if !fsm.CanAny("calm", "panic") {
+		t.Fatal("expected panic to be possible")
+	}
+	if fsm.CanAny("calm", "clear") {
+		t.Fatal("expected neither calm nor clear to be possible")
+	}
+
+	fsm.Event("warn")
+	if fsm.CanAll("panic") || fsm.CanAny("warn", "panic") {
+		t.Fatal("expected no event to be possible while a startState is pending")
+	}
+}
+
+func TestGoto(t *testing.T) {
+	fsm := NewStateMachine(
+		"start",
+		Events{
+			{Name: "route", Src: []string{"start"}, Dst: "routing"},
+			{Name: "continue", Src: []string{"routing"}, Dst: "done"},
+		},
+		Handlers{
+			"enter_routing": func(e *Event) {
+				e.Goto("continue")
+			},
+		},
+	)
+	var queued []string
+	fsm.OnQueue(func(event string, depth int) {
+		queued = append(queued, fmt.Sprintf("%s %d", event, depth))
+	})
+	if err := fsm.Event("route"); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.Current() != "done" {
+		t.Fatalf("expected done, got %s", fsm.Current())
+	}
+	if fmt.Sprint(queued) != "[continue 1]" {
+		t.Fatalf("expected [continue 1], got %v", queued)
+	}
+}
+
+func TestGotoMaxChainDepth(t *testing.T) {
+	fsm := NewStateMachine(
+		"ping",
+		Events{
+			{Name: "toggle", Src: []string{"ping"}, Dst: "pong"},
+			{Name: "toggle", Src: []string{"pong"}, Dst: "ping"},
+		},
+		Handlers{
+			"enter_state": func(e *Event) {
+				e.Goto("toggle")
+			},
+		},
+		WithMaxChainDepth(3),
+	)
+	err := fsm.Event("toggle")
+	if err == nil || err.Error() != "event toggle exceeds the maximum chain depth of 3" {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if changes, _ := fsm.ChangesSince(""); len(changes) != 4 {
+		t.Fatalf("expected 4 transitions, got %d", len(changes))
+	}
+}
+
+func TestSrcExcept(t *testing.T) {
+	fsm := NewStateMachine(
+		"idle",
+		Events{
+			{Name: "start", Src: []string{"idle"}, Dst: "running"},
+			{Name: "pause", Src: []string{"running"}, Dst: "paused"},
+			{Name: "lock", Src: []string{"idle"}, Dst: "locked"},
+			{Name: "unlock", Src: []string{"locked"}, Dst: "idle"},
+			{Name: "reset", SrcExcept: []string{"locked"}, Dst: "idle"},
+			{Name: "reset", Src: []string{"paused"}, Dst: "running"},
+		},
+		Handlers{},
+	)
+
+	fsm.Event("start")
+	if err := fsm.Event("reset"); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.Current() != "idle" {
+		t.Fatalf("expected idle, got %s", fsm.Current())
+	}
+
+	fsm.Event("start")
+	fsm.Event("pause")
+	if err := fsm.Event("reset"); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.Current() != "running" {
+		t.Fatalf("expected the exact source to take precedence, got %s", fsm.Current())
+	}
+
+	fsm.Event("reset")
+	fsm.Event("lock")
+	err := fsm.Event("reset")
+	if err == nil || err.Error() != "event reset inappropriate in current state locked" {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	fsm.AddState("archived")
+	if events := fsm.AvailableTransitionsFrom("archived"); fmt.Sprint(events) != "[reset]" {
+		t.Fatal("expected reset to be possible from a state added later")
+	}
+}
+
+func TestSrcPattern(t *testing.T) {
+	fsm := NewStateMachine(
+		"step_1",
+		Events{
+			{Name: "next", SrcPattern: regexp.MustCompile(`^step_\d+$`), DstFromSrc: func(src string) string {
+				n, _ := strconv.Atoi(strings.TrimPrefix(src, "step_"))
+				return fmt.Sprintf("step_%d", n+1)
+			}},
+			{Name: "finish", Src: []string{"step_3"}, Dst: "done"},
+		},
+		Handlers{},
+	)
+	fsm.AddState("step_1")
+	fsm.AddState("step_2")
+
+	for _, expected := range []string{"step_2", "step_3"} {
+		if err := fsm.Event("next"); err != nil {
+			t.Fatal(err)
+		}
+		if fsm.Current() != expected {
+			t.Fatalf("expected %s, got %s", expected, fsm.Current())
+		}
+	}
+	err := fsm.Event("next")
+	if err == nil || err.Error() != "event next: destination step_4 is not a known state" {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if fsm.Current() != "step_3" {
+		t.Fatalf("expected step_3, got %s", fsm.Current())
+	}
+	fsm.Event("finish")
+	if fsm.Can("next") {
+		t.Fatal("expected next not to match done")
+	}
+}
+
+func TestAlias(t *testing.T) {
+	var calls []string
+	fsm := NewStateMachine(
+		"open",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+			{Name: "close", Src: []string{"open"}, Dst: "closed"},
+		},
+		Handlers{
+			"before_close": func(e *Event) {
+				calls = append(calls, "before_close:"+e.Name)
+			},
+			"after_close": func(e *Event) {
+				calls = append(calls, "after_close:"+e.Name)
+			},
+		},
+	)
+	if err := fsm.Alias("shut", "close"); err != nil {
+		t.Fatal(err)
+	}
+	if !fsm.Can("shut") {
+		t.Fatal("expected shut to be possible")
+	}
+	if err := fsm.Event("shut"); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.Current() != "closed" {
+		t.Fatalf("expected closed, got %s", fsm.Current())
+	}
+	expected := []string{"before_close:close", "after_close:close"}
+	if fmt.Sprint(calls) != fmt.Sprint(expected) {
+		t.Fatalf("expected %v, got %v", expected, calls)
+	}
+}
+
+func TestAliasErrors(t *testing.T) {
+	fsm := NewStateMachine(
+		"open",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+			{Name: "close", Src: []string{"open"}, Dst: "closed"},
+		},
+		Handlers{},
+	)
+	if err := fsm.Alias("open", "close"); err == nil || err.Error() != "alias open shadows an existing event" {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := fsm.Alias("shut", "slam"); err == nil || err.Error() != "alias shut refers to unknown event slam" {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestStateData(t *testing.T) {
+	var leaving interface{}
+	fsm := NewStateMachine(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+			{Name: "close", Src: []string{"open"}, Dst: "closed"},
+		},
+		Handlers{
+			"enter_open": func(e *Event) {
+				e.SetStateData("session")
+			},
+			"leave_open": func(e *Event) {
+				leaving = e.StateMachine.StateData()
+			},
+		},
+	)
+	if fsm.StateData() != nil {
+		t.Fatal("expected no state data initially")
+	}
+	fsm.Event("open")
+	if fsm.StateData() != "session" {
+		t.Fatalf("expected session, got %v", fsm.StateData())
+	}
+	fsm.Event("close")
+	if leaving != "session" {
+		t.Fatalf("expected leave_open to see session, got %v", leaving)
+	}
+	if fsm.StateData() != nil {
+		t.Fatalf("expected state data to be discarded, got %v", fsm.StateData())
+	}
+
+	fsm.Event("open")
+	if err := fsm.Restore(Snapshot{Current: "closed"}); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.StateData() != nil {
+		t.Fatalf("expected state data to be discarded by Restore, got %v", fsm.StateData())
+	}
+}
+
+func TestExecuteWithDstFunc(t *testing.T) {
+	fsm := NewStateMachine(
+		"pending",
+		Events{
+			{Name: "review", Src: []string{"pending"}, DstFunc: func(args []interface{}) string {
+				if len(args) > 0 && args[0] == "approve" {
+					return "approved"
+				}
+				return "rejected"
+			}},
+			{Name: "reopen", Src: []string{"approved", "rejected"}, Dst: "pending"},
+		},
+		Handlers{
+			"leave_pending": func(e *Event) {
+				e.Async()
+			},
+		},
+	)
+
+	fsm.Event("review")
+	if err := fsm.ExecuteWith("approve"); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.Current() != "approved" {
+		t.Fatalf("expected approved, got %s", fsm.Current())
+	}
+
+	if err := fsm.ExecuteWith(); err == nil {
+		t.Fatal("expected an error without a pending transition")
+	}
+}
+
+func TestExecuteWithStaticDst(t *testing.T) {
+	var args []interface{}
+	fsm := NewStateMachine(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+		},
+		Handlers{
+			"leave_start": func(e *Event) {
+				e.Async()
+			},
+			"after_run": func(e *Event) {
+				args = e.Args
+			},
+		},
+	)
+	fsm.Event("run", 1)
+	if err := fsm.ExecuteWith(2); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.Current() != "end" {
+		t.Fatalf("expected end, got %s", fsm.Current())
+	}
+	if fmt.Sprint(args) != "[1 2]" {
+		t.Fatalf("expected merged args, got %v", args)
+	}
+}
+
+func TestLonghandHandlerWins(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		var winner string
+		fsm := NewStateMachine(
+			"start",
+			Events{
+				{Name: "run", Src: []string{"start"}, Dst: "end"},
+			},
+			Handlers{
+				"end": func(e *Event) {
+					winner = "end"
+				},
+				"enter_end": func(e *Event) {
+					winner = "enter_end"
+				},
+				"run": func(e *Event) {
+					winner += ",run"
+				},
+				"after_run": func(e *Event) {
+					winner += ",after_run"
+				},
+			},
+		)
+		fsm.Event("run")
+		if winner != "enter_end,after_run" {
+			t.Fatalf("expected the full names to win, got %s", winner)
+		}
+	}
+}
+
+func TestCurrentWithPending(t *testing.T) {
+	fsm := NewStateMachine(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+		},
+		Handlers{
+			"leave_start": func(e *Event) {
+				e.Async()
+			},
+		},
+	)
+	if state, to, pending := fsm.CurrentWithPending(); state != "start" || to != "" || pending {
+		t.Fatalf("unexpected %s, %s, %v", state, to, pending)
+	}
+	fsm.Event("run")
+	if state, to, pending := fsm.CurrentWithPending(); state != "start" || to != "end" || !pending {
+		t.Fatalf("unexpected %s, %s, %v", state, to, pending)
+	}
+	fsm.Excute()
+	if state, to, pending := fsm.CurrentWithPending(); state != "end" || to != "" || pending {
+		t.Fatalf("unexpected %s, %s, %v", state, to, pending)
+	}
+}
+
+func TestTryEvent(t *testing.T) {
+	fsm := NewStateMachine(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+			{Name: "stay", Src: []string{"start", "end"}, Dst: "end"},
+		},
+		Handlers{},
+	)
+
+	if changed, err := fsm.TryEvent("stay"); !changed || err != nil {
+		t.Fatalf("expected a change, got %v, %v", changed, err)
+	}
+	if changed, err := fsm.TryEvent("stay"); changed || err != nil {
+		t.Fatalf("expected a no-op, got %v, %v", changed, err)
+	}
+	if changed, err := fsm.TryEvent("run"); changed || err != nil {
+		t.Fatalf("expected an inappropriate event to be skipped, got %v, %v", changed, err)
+	}
+	if changed, err := fsm.TryEvent("walk"); changed || err == nil {
+		t.Fatalf("expected an error for an unknown event, got %v, %v", changed, err)
+	}
+}
+
+func TestTransition(t *testing.T) {
+	fsm := NewStateMachine(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+			{Name: "wait", Src: []string{"end"}, Dst: "waiting"},
+			{Name: "stop", Src: []string{"end"}, Dst: "stopped"},
+		},
+		Handlers{
+			"before_stop": func(e *Event) {
+				e.Cancel()
+			},
+			"leave_end": func(e *Event) {
+				if e.Name == "wait" {
+					e.Async()
+				}
+			},
+		},
+	)
+
+	if state, err := fsm.Transition("run"); state != "end" || err != nil {
+		t.Fatalf("expected end, got %s, %v", state, err)
+	}
+	if state, err := fsm.Transition("stop"); state != "end" || err != nil {
+		t.Fatalf("expected the cancelled event to stay in end, got %s, %v", state, err)
+	}
+	if state, err := fsm.Transition("wait"); state != "end" || err != nil {
+		t.Fatalf("expected the pending event to stay in end, got %s, %v", state, err)
+	}
+	if state, err := fsm.Transition("run"); state != fsm.Current() || err == nil {
+		t.Fatalf("expected an error in %s, got %s, %v", fsm.Current(), state, err)
+	}
+}
+
+func TestOnUnknownEvent(t *testing.T) {
+	fsm := NewStateMachine(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+		},
+		Handlers{},
+	)
+	var logged []string
+	fsm.OnUnknownEvent(func(event string, args []interface{}) error {
+		logged = append(logged, fmt.Sprint(event, args))
+		if event == "crash" {
+			return fmt.Errorf("refusing %s", event)
+		}
+		return nil
+	})
+
+	if err := fsm.Event("walk", 1); err != nil {
+		t.Fatalf("expected walk to be swallowed, got %v", err)
+	}
+	if err := fsm.Event("crash"); err == nil || err.Error() != "refusing crash" {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := fsm.Event("run"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fsm.Event("run").(*InvalidEventError); !ok {
+		t.Fatal("expected an inappropriate event to still fail")
+	}
+	if fmt.Sprint(logged) != "[walk[1] crash[]]" {
+		t.Fatalf("expected walk and crash to be logged, got %v", logged)
+	}
+}
+
+func TestEventIfExists(t *testing.T) {
+	fsm := NewStateMachine(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+		},
+		Handlers{},
+	)
+
+	if fired, err := fsm.EventIfExists("walk"); fired || err != nil {
+		t.Fatalf("expected an unknown event to be skipped, got %v, %v", fired, err)
+	}
+	if fired, err := fsm.EventIfExists("run"); !fired || err != nil {
+		t.Fatalf("expected run to be fired, got %v, %v", fired, err)
+	}
+	if fsm.Current() != "end" {
+		t.Fatalf("expected end, got %s", fsm.Current())
+	}
+	fired, err := fsm.EventIfExists("run")
+	if _, ok := err.(*InvalidEventError); !fired || !ok {
+		t.Fatalf("expected InvalidEventError, got %v, %v", fired, err)
+	}
+}
+
+func TestOnReject(t *testing.T) {
+	var rejected []string
+	fsm := NewStateMachine(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+			{Name: "reset", Src: []string{"end"}, Dst: "start"},
+		},
+		Handlers{
+			"leave_start": func(e *Event) {
+				e.Async()
+			},
+		},
+	)
+	fsm.OnReject(func(event, state string, err error) {
+		rejected = append(rejected, fmt.Sprintf("%s@%s:%T", event, state, err))
+	})
+
+	fsm.Event("walk")
+	fsm.Event("reset")
+	fsm.Event("run")
+	fsm.Event("reset")
+
+	expected := []string{
+		"walk@start:*statemachine.UnknownEventError",
+		"reset@start:*statemachine.InvalidEventError",
+		"reset@start:*statemachine.InTransitionError",
+	}
+	if fmt.Sprint(rejected) != fmt.Sprint(expected) {
+		t.Fatalf("expected %v, got %v", expected, rejected)
+	}
+}
+
+func TestTransitionHandler(t *testing.T) {
+	var transitions []string
+	fsm := NewStateMachine(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+			{Name: "close", Src: []string{"open"}, Dst: "closed"},
+			{Name: "stay", Src: []string{"closed"}, Dst: "closed"},
+		},
+		Handlers{
+			"transition": func(e *Event) {
+				transitions = append(transitions, e.Name+":"+e.Src+">"+e.Dst)
+			},
+			"leave_open": func(e *Event) {
+				if len(e.Args) > 0 {
+					e.Cancel()
+				}
+			},
+		},
+	)
+
+	fsm.Event("open")
+	fsm.Event("close", "cancel")
+	fsm.Event("close")
+	fsm.Event("stay")
+	fsm.Event("open")
+
+	expected := []string{"open:closed>open", "close:open>closed", "open:closed>open"}
+	if fmt.Sprint(transitions) != fmt.Sprint(expected) {
+		t.Fatalf("expected %v, got %v", expected, transitions)
+	}
+}
+
+func TestCanFrom(t *testing.T) {
+	fsm := NewStateMachine(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+			{Name: "close", Src: []string{"open"}, Dst: "closed"},
+		},
+		Handlers{
+			"leave_closed": func(e *Event) {
+				e.Async()
+			},
+		},
+	)
+	fsm.Event("open")
+
+	if !fsm.CanFrom("open", "close") {
+		t.Fatal("expected close to be possible from open")
+	}
+	if !fsm.CanFrom("closed", "open") {
+		t.Fatal("expected open to be possible from closed while pending")
+	}
+	if fsm.CanFrom("closed", "close") || fsm.CanFrom("closed", "walk") || fsm.CanFrom("ajar", "open") {
+		t.Fatal("expected invalid pairs to be rejected")
+	}
+
+	if dst, ok := fsm.DestinationFrom("open", "close"); !ok || dst != "closed" {
+		t.Fatalf("expected closed, got %q, %v", dst, ok)
+	}
+	if dst, ok := fsm.DestinationFrom("open", "open"); ok || dst != "" {
+		t.Fatalf("expected no destination, got %q, %v", dst, ok)
+	}
+	if fsm.Current() != "closed" {
+		t.Fatalf("expected closed, got %s", fsm.Current())
+	}
+}
+
+func TestEventAsync(t *testing.T) {
+	entered := make(chan struct{})
+	fsm := NewStateMachine(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+		},
+		Handlers{
+			"enter_end": func(e *Event) {
+				<-entered
+			},
+		},
+	)
+
+	done, err := fsm.EventAsync("run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	close(entered)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if fsm.Current() != "end" {
+		t.Fatalf("expected end, got %s", fsm.Current())
+	}
+
+	if _, err := fsm.EventAsync("run"); err == nil {
+		t.Fatal("expected an error for an invalid event")
+	}
+}
+
+func TestEventAsyncExcute(t *testing.T) {
+	fsm := NewStateMachine(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+		},
+		Handlers{
+			"leave_start": func(e *Event) {
+				e.Async()
+			},
+			"enter_end": func(e *Event) {
+				e.Err = fmt.Errorf("entered")
+			},
+		},
+	)
+
+	done, err := fsm.EventAsync("run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("expected the transition to wait for Excute, got %v", err)
+	default:
+	}
+	if err := fsm.Excute(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err == nil || err.Error() != "entered" {
+		t.Fatalf("expected the enter_ error, got %v", err)
+	}
+	if fsm.Current() != "end" {
+		t.Fatalf("expected end, got %s", fsm.Current())
+	}
+}
+
+func TestEventAsyncHandsBack(t *testing.T) {
+	fsm := NewStateMachine(
+		"start",
+		Events{
+			{Name: "go", Src: []string{"start"}, Dst: "end"},
+			{Name: "back", Src: []string{"end"}, Dst: "start"},
+		},
+		Handlers{},
+	)
+	for i := 0; i < 100; i++ {
+		done, err := fsm.EventAsync("go")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+		if err := fsm.Event("back"); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestEventAsyncDropped(t *testing.T) {
+	newMachine := func(opts ...Option) *StateMachine {
+		return NewStateMachine(
+			"start",
+			Events{
+				{Name: "run", Src: []string{"start"}, Dst: "end"},
+				{Name: "skip", Src: []string{"start"}, Dst: "skipped"},
+			},
+			Handlers{
+				"leave_start": func(e *Event) {
+					if e.Name == "run" {
+						e.Async()
+					}
+				},
+			},
+			opts...,
+		)
+	}
+
+	fsm := newMachine(WithInProgressPolicy(CancelPending))
+	done, err := fsm.EventAsync("run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fsm.Event("skip"); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err == nil || err.Error() != "event run canceled by event skip" {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	fsm = newMachine()
+	done, err = fsm.EventAsync("run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fsm.EventChain("skip"); err == nil {
+		t.Fatal("expected the chain to fail")
+	}
+	if err := <-done; err == nil || err.Error() != "event run dropped by the rollback of an event chain" {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestNilHandler(t *testing.T) {
+	fsm := NewStateMachine(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+		},
+		Handlers{
+			"after_run": nil,
+		},
+	)
+	if err := fsm.Event("run"); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.Current() != "end" {
+		t.Fatalf("expected end, got %s", fsm.Current())
+	}
+}
+
+func TestHierarchicalStates(t *testing.T) {
+	fsm := NewStateMachine(
+		"idle",
+		Events{
+			{Name: "start", Src: []string{"idle"}, Dst: "active.running"},
+			{Name: "pause", Src: []string{"active.running"}, Dst: "active.paused"},
+			{Name: "resume", Src: []string{"active.paused"}, Dst: "active.running"},
+			{Name: "stop", Src: []string{"active"}, Dst: "idle"},
+		},
+		Handlers{},
+	)
+
+	fsm.Event("start")
+	fsm.Event("pause")
+	if fsm.Current() != "active.paused" {
+		t.Fatalf("expected active.paused, got %s", fsm.Current())
+	}
+	if !fsm.Can("stop") {
+		t.Fatal("expected stop to be inherited from active")
+	}
+	if events := fsm.AvailableTransitions(); fmt.Sprint(events) != "[resume stop]" {
+		t.Fatalf("expected [resume stop], got %v", events)
+	}
+	if err := fsm.Event("stop"); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.Current() != "idle" {
+		t.Fatalf("expected idle, got %s", fsm.Current())
+	}
+
+	err := fsm.Event("pause")
+	if _, ok := err.(*InvalidEventError); !ok {
+		t.Fatalf("expected InvalidEventError, got %v", err)
+	}
+}
+
+func TestAppendArg(t *testing.T) {
+	var got []interface{}
+	fsm := NewStateMachine(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+		},
+		Handlers{
+			"before_run": func(e *Event) {
+				e.AppendArg(42)
+			},
+			"leave_start": func(e *Event) {
+				e.Async()
+			},
+			"after_run": func(e *Event) {
+				got = e.Args
+			},
+		},
+	)
+
+	fsm.Event("run", "input")
+	if err := fsm.Excute(); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(got) != "[input 42]" {
+		t.Fatalf("expected [input 42], got %v", got)
+	}
+}
+
+func TestExcuteFromEnterHandler(t *testing.T) {
+	var excuteErr, executeWithErr error
+	fsm := NewStateMachine(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+		},
+		Handlers{
+			"leave_start": func(e *Event) {
+				e.Async()
+			},
+			"enter_end": func(e *Event) {
+				excuteErr = e.StateMachine.Excute()
+				executeWithErr = e.StateMachine.ExecuteWith("late")
+			},
+		},
+	)
+
+	fsm.Event("run")
+	if err := fsm.Excute(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := excuteErr.(*ReentrantExecuteError); !ok {
+		t.Fatalf("expected ReentrantExecuteError from Excute, got %v", excuteErr)
+	}
+	if _, ok := executeWithErr.(*ReentrantExecuteError); !ok {
+		t.Fatalf("expected ReentrantExecuteError from ExecuteWith, got %v", executeWithErr)
+	}
+	if fsm.Current() != "end" {
+		t.Fatalf("expected end, got %s", fsm.Current())
+	}
+	if _, ok := fsm.Excute().(*NotInTransitionError); !ok {
+		t.Fatal("expected the transition to be complete")
+	}
+}
+
+func TestFinalHandler(t *testing.T) {
+	var finals []string
+	fsm := NewStateMachine(
+		"ordered",
+		Events{
+			{Name: "pack", Src: []string{"ordered"}, Dst: "packed"},
+			{Name: "ship", Src: []string{"packed"}, Dst: "shipped"},
+			{Name: "deliver", Src: []string{"shipped"}, Dst: "delivered"},
+		},
+		Handlers{
+			"final": func(e *Event) {
+				finals = append(finals, e.Dst)
+			},
+		},
+	)
+
+	for _, event := range []string{"pack", "ship"} {
+		fsm.Event(event)
+		if len(finals) != 0 {
+			t.Fatalf("expected no final hook after %s, got %v", event, finals)
+		}
+	}
+	fsm.Event("deliver")
+	if fmt.Sprint(finals) != "[delivered]" {
+		t.Fatalf("expected [delivered], got %v", finals)
+	}
+}
+
+func TestErrorHandler(t *testing.T) {
+	var observed []string
+	fsm := NewStateMachine(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+		},
+		Handlers{
+			"after_run": func(e *Event) {
+				e.Err = fmt.Errorf("disk full")
+			},
+			"error": func(e *Event) {
+				observed = append(observed, e.Name+": "+e.Err.Error())
+				e.Err = fmt.Errorf("run failed: %w", e.Err)
+			},
+		},
+	)
+
+	err := fsm.Event("run")
+	if err == nil || err.Error() != "run failed: disk full" {
+		t.Fatalf("expected the wrapped error, got %v", err)
+	}
+	if fmt.Sprint(observed) != "[run: disk full]" {
+		t.Fatalf("expected the error hook to run once, got %v", observed)
+	}
+}
+
+func TestHookStateShorthand(t *testing.T) {
+	var entered []string
+	enter := func(e *Event) {
+		entered = append(entered, e.Dst)
+	}
+	fsm := NewStateMachine(
+		"start",
+		Events{
+			{Name: "fail", Src: []string{"start"}, Dst: "error"},
+			{Name: "finish", Src: []string{"error"}, Dst: "final"},
+			{Name: "transition", Src: []string{"start"}, Dst: "final"},
+		},
+		Handlers{
+			"error": enter,
+			"final": enter,
+			"transition": func(e *Event) {
+				entered = append(entered, "after "+e.Name)
+			},
+		},
+	)
+	for _, event := range []string{"fail", "finish"} {
+		if err := fsm.Event(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if fmt.Sprint(entered) != "[error final]" {
+		t.Fatalf("expected the shorthands to run on entering the states, got %v", entered)
+	}
+
+	fsm.SetState("start")
+	entered = nil
+	fsm.Event("transition")
+	if fmt.Sprint(entered) != "[final after transition]" {
+		t.Fatalf("expected the shorthand to run after the transition event, got %v", entered)
+	}
+}
+
+func TestEventWith(t *testing.T) {
+	var user interface{}
+	var missing bool
+	fsm := NewStateMachine(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end", Guard: func(e *Event) bool {
+				_, ok := e.NamedArg("user")
+				return ok
+			}},
+		},
+		Handlers{
+			"after_run": func(e *Event) {
+				user, _ = e.NamedArg("user")
+				_, found := e.NamedArg("role")
+				missing = !found
+			},
+		},
+	)
+
+	if err := fsm.EventWith("run", map[string]interface{}{}); err == nil {
+		t.Fatal("expected the guard to reject run without a user")
+	}
+	if err := fsm.EventWith("run", map[string]interface{}{"user": "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	if user != "alice" || !missing {
+		t.Fatalf("expected user alice and no role, got %v, %v", user, missing)
+	}
+}
+
+func TestEventWithMacroAndGoto(t *testing.T) {
+	var users []interface{}
+	fsm := NewStateMachine(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+			{Name: "close", Src: []string{"open"}, Dst: "closed"},
+			{Name: "lock", Src: []string{"closed"}, Dst: "locked"},
+		},
+		Handlers{
+			"after_event": func(e *Event) {
+				user, _ := e.NamedArg("user")
+				users = append(users, user)
+				if e.Name == "close" {
+					e.Goto("lock")
+				}
+			},
+		},
+	)
+	fsm.DefineMacro("open_close", []string{"open", "close"})
+
+	if err := fsm.EventWith("open_close", map[string]interface{}{"user": "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.Current() != "locked" {
+		t.Fatalf("expected locked, got %s", fsm.Current())
+	}
+	if fmt.Sprint(users) != "[alice alice alice]" {
+		t.Fatalf("expected every step to see alice, got %v", users)
+	}
+}
+
+func TestAbortAsync(t *testing.T) {
+	var aborted []string
+	fsm := NewStateMachine(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+		},
+		Handlers{
+			"leave_start": func(e *Event) {
+				if len(e.Args) == 0 {
+					e.Async()
+				}
+			},
+			"abort_start": func(e *Event) {
+				aborted = append(aborted, e.Name+">"+e.Dst)
+			},
+		},
+	)
+
+	if _, ok := fsm.AbortAsync().(*NotInTransitionError); !ok {
+		t.Fatal("expected NotInTransitionError without a pending transition")
+	}
+
+	fsm.Event("run")
+	if err := fsm.AbortAsync(); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.Current() != "start" {
+		t.Fatalf("expected start, got %s", fsm.Current())
+	}
+	if fmt.Sprint(aborted) != "[run>end]" {
+		t.Fatalf("expected abort_start to be called, got %v", aborted)
+	}
+	if _, ok := fsm.Excute().(*NotInTransitionError); !ok {
+		t.Fatal("expected the transition to be discarded")
+	}
+
+	if err := fsm.Event("run", "sync"); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.Current() != "end" {
+		t.Fatalf("expected end, got %s", fsm.Current())
+	}
+}
+
+func TestTransitionAction(t *testing.T) {
+	var actions []string
+	fsm := NewStateMachine(
+		"start",
+		Events{
+			{Name: "first", Src: []string{"start"}, Dst: "one"},
+			{Name: "second", Src: []string{"start"}, Dst: "two"},
+			{Name: "reset", Src: []string{"one"}, Dst: "start", Action: func(e *Event) {
+				actions = append(actions, "reset one")
+			}},
+			{Name: "reset", Src: []string{"two"}, Dst: "start", Action: func(e *Event) {
+				actions = append(actions, "reset two")
+			}},
+		},
+		Handlers{},
+	)
+
+	fsm.Event("second")
+	fsm.Event("reset")
+	if fmt.Sprint(actions) != "[reset two]" {
+		t.Fatalf("expected [reset two], got %v", actions)
+	}
+	fsm.Event("first")
+	fsm.Event("reset")
+	if fmt.Sprint(actions) != "[reset two reset one]" {
+		t.Fatalf("expected [reset two reset one], got %v", actions)
+	}
+}
+
+func TestRenameState(t *testing.T) {
+	var entered []string
+	events := Events{
+		{Name: "warn", Src: []string{"green"}, Dst: "yellow"},
+		{Name: "panic", Src: []string{"yellow", "green"}, Dst: "red"},
+		{Name: "calm", Src: []string{"red"}, Dst: "yellow"},
+		{Name: "clear", Src: []string{"yellow"}, Dst: "green"},
+	}
+	fsm := NewStateMachine(
+		"green",
+		events,
+		Handlers{
+			"enter_yellow": func(e *Event) {
+				entered = append(entered, e.Dst)
+			},
+		},
+	)
+
+	if err := fsm.RenameState("yellow", "red"); err == nil {
+		t.Fatal("expected an error for an existing state")
+	}
+	if err := fsm.RenameState("blue", "amber"); err == nil {
+		t.Fatal("expected an error for an unknown state")
+	}
+	if err := fsm.RenameState("yellow", "amber"); err != nil {
+		t.Fatal(err)
+	}
+	if events[1].Src[0] != "yellow" {
+		t.Fatal("expected the events passed to NewStateMachine to be left alone")
+	}
+
+	if err := fsm.Event("warn"); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.Current() != "amber" {
+		t.Fatalf("expected amber, got %s", fsm.Current())
+	}
+	if err := fsm.Event("panic"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fsm.Event("calm"); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(entered) != "[amber amber]" {
+		t.Fatalf("expected enter handler for amber twice, got %v", entered)
+	}
+	if states := fsm.States(); fmt.Sprint(states) != "[amber green red]" {
+		t.Fatalf("expected [amber green red], got %v", states)
+	}
+	if !fsm.HasHandler("enter_amber") || fsm.HasHandler("enter_yellow") {
+		t.Fatal("expected the handler to follow the rename")
+	}
+}
+
+func TestTransitionBefore(t *testing.T) {
+	var amounts []interface{}
+	var reported error
+	fsm := NewStateMachine(
+		"cart",
+		Events{
+			{Name: "pay", Src: []string{"cart"}, Dst: "paid", Before: func(e *Event) error {
+				amount, err := Arg[int](e, 0)
+				if err != nil {
+					return err
+				}
+				if amount <= 0 {
+					return fmt.Errorf("amount %d is not positive", amount)
+				}
+				e.Args[0] = amount * 100
+				return nil
+			}},
+		},
+		Handlers{
+			"after_pay": func(e *Event) {
+				amounts = append(amounts, e.Args...)
+			},
+			"error": func(e *Event) {
+				reported = e.Err
+			},
+		},
+	)
+
+	err := fsm.Event("pay", -5)
+	if err == nil || err.Error() != "amount -5 is not positive" {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if reported != err {
+		t.Fatalf("expected the error handler to see %v, got %v", err, reported)
+	}
+	if fsm.Current() != "cart" {
+		t.Fatalf("expected cart, got %s", fsm.Current())
+	}
+
+	if err := fsm.Event("pay", 5); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(amounts) != "[500]" {
+		t.Fatalf("expected [500], got %v", amounts)
+	}
+}
@@ -0,0 +1,154 @@
+package statemachine
+
+import (
+	"sort"
+)
+
+// UsageReport lists the parts of a machine that have not been used, to help
+// find dead states, transitions and handlers in large machines.
+type UsageReport struct {
+	// UnreachableStates are the sorted states that cannot be reached from
+	// the initial state.
+	UnreachableStates []string
+	// UntraversedTransitions are the transitions that have never been
+	// taken, with a single source each, sorted by source, event and
+	// destination.
+	UntraversedTransitions []EventDesc
+	// UninvokedHandlers are the sorted names of registered handlers that
+	// have never run, in their long form.
+	UninvokedHandlers []string
+}
+
+// UsageReport returns what has not been used since the machine was
+// constructed.
+func (machine *StateMachine) UsageReport() UsageReport {
+	var report UsageReport
+
+	report.UnreachableStates = machine.UnreachableStates()
+
+	for _, e := range machine.edges() {
+		if !machine.traversed[e] {
+			report.UntraversedTransitions = append(report.UntraversedTransitions,
+				EventDesc{Name: e.event, Src: []string{e.src}, Dst: e.dst})
+		}
+	}
+
+	for key := range machine.handlers {
+		if !machine.invoked[key] {
+			report.UninvokedHandlers = append(report.UninvokedHandlers, key.String())
+		}
+	}
+	sort.Strings(report.UninvokedHandlers)
+
+	return report
+}
+
+// UnreachableStates returns the sorted known states that cannot be reached
+// from the initial state, which often points at a typo in a Src or Dst.
+// Guards are ignored.
+func (machine *StateMachine) UnreachableStates() []string {
+	var states []string
+	reachable := machine.reachableFrom(machine.initial)
+	for state := range machine.allStates {
+		if !reachable[state] {
+			states = append(states, state)
+		}
+	}
+	sort.Strings(states)
+	return states
+}
+
+// ReachableStates returns the sorted states that can still be reached from
+// the current state, including the current state itself. Unlike
+// UnreachableStates it follows the machine as it advances. Guards are
+// ignored.
+func (machine *StateMachine) ReachableStates() []string {
+	var states []string
+	for state := range machine.reachableFrom(machine.Current()) {
+		states = append(states, state)
+	}
+	sort.Strings(states)
+	return states
+}
+
+// PathTo returns the shortest sequence of events that leads from the current
+// state to target, or a *NoPathError if there is none. The path is empty if
+// the machine already is in target. Only the structure of the graph is
+// considered: guards are ignored, so firing the events may still fail. Among
+// paths of equal length the one whose events sort first is returned.
+func (machine *StateMachine) PathTo(target string) ([]string, error) {
+	from := machine.Current()
+
+	// via maps every state found so far to the edge it was first reached by.
+	via := map[string]edge{from: {}}
+	queue := []string{from}
+	edges := machine.edges()
+	for len(queue) > 0 && queue[0] != target {
+		current := queue[0]
+		queue = queue[1:]
+		for _, e := range machine.outgoing(current, edges) {
+			if _, ok := via[e.dst]; !ok {
+				via[e.dst] = e
+				queue = append(queue, e.dst)
+			}
+		}
+	}
+	if _, ok := via[target]; !ok {
+		return nil, &NoPathError{from, target}
+	}
+
+	var path []string
+	for state := target; state != from; state = via[state].src {
+		path = append([]string{via[state].event}, path...)
+	}
+	return path, nil
+}
+
+// reachableFrom returns the set of states that can be reached from state,
+// including state itself and the known parents of every reached state.
+// Guards are ignored.
+func (machine *StateMachine) reachableFrom(state string) map[string]bool {
+	edges := machine.edges()
+	visited := map[string]bool{state: true}
+	queue := []string{state}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for _, e := range machine.outgoing(current, edges) {
+			if !visited[e.dst] {
+				visited[e.dst] = true
+				queue = append(queue, e.dst)
+			}
+		}
+	}
+
+	reachable := make(map[string]bool)
+	for state := range visited {
+		reachable[state] = true
+		for _, parent := range ancestors(state) {
+			if machine.allStates[parent] {
+				reachable[parent] = true
+			}
+		}
+	}
+	return reachable
+}
+
+// outgoing returns the edges that leave state, including those inherited
+// from its parents, with state as their source, sorted by event and
+// destination.
+func (machine *StateMachine) outgoing(state string, edges []edge) []edge {
+	var out []edge
+	for _, e := range edges {
+		if src, ok := machine.source(e.event, state); ok && src == e.src {
+			out = append(out, edge{state, e.event, e.dst})
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].event != out[j].event {
+			return out[i].event < out[j].event
+		}
+		return out[i].dst < out[j].dst
+	})
+	return out
+}
@@ -0,0 +1,186 @@
+package statemachine
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestUsageReport(t *testing.T) {
+	noop := func(e *Event) {}
+	fsm := NewStateMachine(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "running"},
+			{Name: "stop", Src: []string{"running"}, Dst: "start"},
+			{Name: "finish", Src: []string{"running"}, Dst: "done"},
+			{Name: "revive", Src: []string{"orphan"}, Dst: "start"},
+		},
+		Handlers{
+			"enter_running": noop,
+			"enter_done":    noop,
+			"after_event":   noop,
+		},
+	)
+	fsm.Event("run")
+	fsm.Event("stop")
+
+	report := fsm.UsageReport()
+	if expected := []string{"orphan"}; !reflect.DeepEqual(report.UnreachableStates, expected) {
+		t.Fatalf("expected unreachable %v, got %v", expected, report.UnreachableStates)
+	}
+	expectedEdges := []EventDesc{
+		{Name: "revive", Src: []string{"orphan"}, Dst: "start"},
+		{Name: "finish", Src: []string{"running"}, Dst: "done"},
+	}
+	if !reflect.DeepEqual(report.UntraversedTransitions, expectedEdges) {
+		t.Fatalf("expected untraversed %v, got %v", expectedEdges, report.UntraversedTransitions)
+	}
+	if expected := []string{"enter_done"}; !reflect.DeepEqual(report.UninvokedHandlers, expected) {
+		t.Fatalf("expected uninvoked %v, got %v", expected, report.UninvokedHandlers)
+	}
+}
+
+func TestUnreachableStates(t *testing.T) {
+	fsm := NewStateMachine(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+			{Name: "close", Src: []string{"open"}, Dst: "closed"},
+			{Name: "close", Src: []string{"opne"}, Dst: "closed"},
+		},
+		Handlers{},
+	)
+	if states := fsm.UnreachableStates(); !reflect.DeepEqual(states, []string{"opne"}) {
+		t.Fatalf("expected [opne], got %v", states)
+	}
+
+	fsm = NewStateMachine(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+			{Name: "close", Src: []string{"open"}, Dst: "closed"},
+		},
+		Handlers{},
+	)
+	if states := fsm.UnreachableStates(); len(states) != 0 {
+		t.Fatalf("expected no unreachable states, got %v", states)
+	}
+}
+
+func TestReachableStates(t *testing.T) {
+	fsm := NewStateMachine(
+		"cart",
+		Events{
+			{Name: "checkout", Src: []string{"cart"}, Dst: "payment"},
+			{Name: "pay", Src: []string{"payment"}, Dst: "shipped"},
+			{Name: "cancel", Src: []string{"cart", "payment"}, Dst: "canceled"},
+			{Name: "deliver", Src: []string{"shipped"}, Dst: "delivered"},
+		},
+		Handlers{},
+	)
+
+	for _, step := range []struct {
+		event    string
+		expected []string
+	}{
+		{"", []string{"canceled", "cart", "delivered", "payment", "shipped"}},
+		{"checkout", []string{"canceled", "delivered", "payment", "shipped"}},
+		{"pay", []string{"delivered", "shipped"}},
+		{"deliver", []string{"delivered"}},
+	} {
+		if step.event != "" {
+			if err := fsm.Event(step.event); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if states := fsm.ReachableStates(); !reflect.DeepEqual(states, step.expected) {
+			t.Fatalf("after %q: expected %v, got %v", step.event, step.expected, states)
+		}
+	}
+}
+
+func TestPathTo(t *testing.T) {
+	fsm := NewStateMachine(
+		"green",
+		Events{
+			{Name: "warn", Src: []string{"green"}, Dst: "yellow"},
+			{Name: "panic", Src: []string{"yellow"}, Dst: "red"},
+			{Name: "panic", Src: []string{"green"}, Dst: "red"},
+			{Name: "calm", Src: []string{"red"}, Dst: "yellow"},
+			{Name: "clear", Src: []string{"yellow"}, Dst: "green"},
+		},
+		Handlers{},
+	)
+	fsm.AddState("broken")
+
+	for _, test := range []struct {
+		target   string
+		expected []string
+	}{
+		{"green", nil},
+		{"yellow", []string{"warn"}},
+		{"red", []string{"panic"}},
+	} {
+		path, err := fsm.PathTo(test.target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(path, test.expected) {
+			t.Fatalf("%s: expected %v, got %v", test.target, test.expected, path)
+		}
+	}
+
+	fsm.Event("panic")
+	path, err := fsm.PathTo("green")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(path, []string{"calm", "clear"}) {
+		t.Fatalf("expected [calm clear], got %v", path)
+	}
+
+	_, err = fsm.PathTo("broken")
+	if _, ok := err.(*NoPathError); !ok || err.Error() != "no path from red to broken" {
+		t.Fatalf("expected NoPathError, got %v", err)
+	}
+}
+
+func TestHierarchicalAnalyses(t *testing.T) {
+	fsm := NewStateMachine(
+		"idle",
+		Events{
+			{Name: "start", Src: []string{"idle"}, Dst: "active.running"},
+			{Name: "pause", Src: []string{"active.running"}, Dst: "active.paused"},
+			{Name: "stop", Src: []string{"active"}, Dst: "idle"},
+		},
+		Handlers{},
+	)
+
+	if final := fsm.FinalStates(); len(final) != 0 {
+		t.Fatalf("expected no final states, got %v", final)
+	}
+	if unreachable := fsm.UnreachableStates(); len(unreachable) != 0 {
+		t.Fatalf("expected every state to be reachable, got %v", unreachable)
+	}
+
+	fsm.Event("start")
+	fsm.Event("pause")
+	if fsm.IsFinal() {
+		t.Fatal("expected active.paused to inherit stop")
+	}
+	path, err := fsm.PathTo("idle")
+	if err != nil || fmt.Sprint(path) != "[stop]" {
+		t.Fatalf("expected [stop], got %v, %v", path, err)
+	}
+	if states := fsm.ReachableStates(); fmt.Sprint(states) != "[active active.paused active.running idle]" {
+		t.Fatalf("unexpected reachable states %v", states)
+	}
+
+	fsm.Event("stop")
+	for _, desc := range fsm.UsageReport().UntraversedTransitions {
+		if desc.Name == "stop" {
+			t.Fatalf("expected the inherited stop to count as traversed, got %v", desc)
+		}
+	}
+}
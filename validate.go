@@ -0,0 +1,174 @@
+package statemachine
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// NewCheckedStateMachine is like NewStateMachine but validates the machine
+// and returns an error describing every problem found:
+//
+// - the initial state is not used by any transition
+//
+// - an event has several transitions without a Guard or Weight from the same
+// source that lead to different destinations, so that only the first can be
+// taken
+//
+// - with WithDeadEndCheck, the destination of a transition has no outgoing
+// transitions, unless a transition into it is marked Terminal
+//
+// - an option is invalid, such as WithInitialState with an unknown state
+//
+// - a handler is nil
+//
+// - the name of a handler does not refer to a known event or state, which is
+// usually a typo such as "enter_opne"
+func NewCheckedStateMachine(initial string, events Events, handlers Handlers, opts ...Option) (*StateMachine, error) {
+	machine := NewStateMachine(initial, events, handlers, opts...)
+	if err := errors.Join(machine.validate(), nilHandlers(handlers), machine.unresolvedHandlers(handlers)); err != nil {
+		return nil, err
+	}
+	return machine, nil
+}
+
+// nilHandlers returns the joined errors for the nil values of handlers, in
+// the order of their names.
+func nilHandlers(handlers Handlers) error {
+	var names []string
+	for name, handler := range handlers {
+		if handler == nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var errs []error
+	for _, name := range names {
+		errs = append(errs, fmt.Errorf("handler %s is nil", name))
+	}
+	return errors.Join(errs...)
+}
+
+// validate returns the joined errors of all checks.
+func (machine *StateMachine) validate() error {
+	var errs []error
+	if !machine.allStates[machine.initial] {
+		errs = append(errs, fmt.Errorf("initial state %s is not a known state", machine.initial))
+	}
+	errs = append(errs, machine.optionErrs...)
+	errs = append(errs, machine.conflicts()...)
+	if machine.deadEndCheck {
+		errs = append(errs, machine.deadEnds()...)
+	}
+	return errors.Join(errs...)
+}
+
+// deadEnds returns an error for every destination without outgoing
+// transitions that no transition marks as Terminal, in sorted order.
+func (machine *StateMachine) deadEnds() []error {
+	terminal := make(map[string]bool)
+	for _, candidates := range machine.states {
+		for _, desc := range candidates {
+			if desc.Dst != "" {
+				terminal[desc.Dst] = terminal[desc.Dst] || desc.Terminal
+			}
+		}
+	}
+
+	var errs []error
+	for _, state := range machine.FinalStates() {
+		if marked, ok := terminal[state]; ok && !marked {
+			errs = append(errs, fmt.Errorf("state %s has no outgoing transitions and is not marked Terminal", state))
+		}
+	}
+	return errs
+}
+
+// conflicts returns an error for every pair of guardless transitions that
+// share an event and source but not a destination, unless they are weighted.
+func (machine *StateMachine) conflicts() []error {
+	var errs []error
+	for _, key := range machine.sortedKeys() {
+		descs := machine.guardless(key)
+		for i := 1; i < len(descs); i++ {
+			if descs[i].Dst != descs[0].Dst {
+				errs = append(errs, fmt.Errorf("event %s from %s has conflicting destinations %s and %s",
+					key.event, key.src, descs[0].Dst, descs[i].Dst))
+			}
+		}
+	}
+	return errs
+}
+
+// IsDeterministic reports whether every event leads to a single destination
+// from each source. Otherwise it also returns the offending transitions, with
+// Src set to the single source concerned and sorted by event and source:
+// those without a Guard that share an event and source with a guardless
+// transition to a different destination. Transitions with a Weight are left
+// out, as choosing between them at random is intended, see EnableWeighted.
+func (machine *StateMachine) IsDeterministic() (bool, []EventDesc) {
+	var offending []EventDesc
+	for _, key := range machine.sortedKeys() {
+		descs := machine.guardless(key)
+		for i := 1; i < len(descs); i++ {
+			if descs[i].Dst != descs[0].Dst {
+				for _, desc := range descs {
+					d := *desc
+					d.Src = []string{key.src}
+					offending = append(offending, d)
+				}
+				break
+			}
+		}
+	}
+	return len(offending) == 0, offending
+}
+
+// guardless returns the transitions of key without a Guard, or nil if any
+// transition of key is weighted.
+func (machine *StateMachine) guardless(key stateKey) []*EventDesc {
+	var descs []*EventDesc
+	for _, desc := range machine.states[key] {
+		if desc.Weight != 0 {
+			return nil
+		}
+		if desc.Guard == nil {
+			descs = append(descs, desc)
+		}
+	}
+	return descs
+}
+
+// sortedKeys returns the keys of the transitions sorted by event and source.
+func (machine *StateMachine) sortedKeys() []stateKey {
+	keys := make([]stateKey, 0, len(machine.states))
+	for key := range machine.states {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].event != keys[j].event {
+			return keys[i].event < keys[j].event
+		}
+		return keys[i].src < keys[j].src
+	})
+	return keys
+}
+
+// unresolvedHandlers returns the joined errors for the names of handlers that
+// do not resolve to a hook, in sorted order.
+func (machine *StateMachine) unresolvedHandlers(handlers Handlers) error {
+	var names []string
+	for name := range handlers {
+		if _, ok := resolveHandler(name, machine.allEvents, machine.allStates); !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var errs []error
+	for _, name := range names {
+		errs = append(errs, fmt.Errorf("handler %s does not refer to a known event or state", name))
+	}
+	return errors.Join(errs...)
+}
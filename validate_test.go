@@ -0,0 +1,167 @@
+package statemachine
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestCheckedConflictingDestinations(t *testing.T) {
+	_, err := NewCheckedStateMachine(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+			{Name: "run", Src: []string{"start", "idle"}, Dst: "alt_end"},
+		},
+		Handlers{},
+	)
+	if err == nil || err.Error() != "event run from start has conflicting destinations end and alt_end" {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestCheckedGuardedDestinations(t *testing.T) {
+	fsm, err := NewCheckedStateMachine(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end", Guard: func(e *Event) bool {
+				return false
+			}},
+			{Name: "run", Src: []string{"start"}, Dst: "alt_end"},
+		},
+		Handlers{},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsm.Event("run")
+	if fsm.Current() != "alt_end" {
+		t.Fatalf("expected alt_end, got %s", fsm.Current())
+	}
+}
+
+func TestCheckedUnknownInitial(t *testing.T) {
+	_, err := NewCheckedStateMachine(
+		"nowhere",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+		},
+		Handlers{},
+	)
+	if err == nil || err.Error() != "initial state nowhere is not a known state" {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestCheckedNilHandler(t *testing.T) {
+	_, err := NewCheckedStateMachine(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+		},
+		Handlers{
+			"after_run": nil,
+		},
+	)
+	if err == nil || err.Error() != "handler after_run is nil" {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestCheckedUnresolvedHandler(t *testing.T) {
+	noop := func(e *Event) {}
+	_, err := NewCheckedStateMachine(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+		},
+		Handlers{
+			"enter_open": noop,
+			"after_opne": noop,
+		},
+	)
+	if err == nil || err.Error() != "handler after_opne does not refer to a known event or state" {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	_, err = NewCheckedStateMachine(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+		},
+		Handlers{
+			"enter_open":  noop,
+			"after_open":  noop,
+			"leave_state": noop,
+			"transition":  noop,
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckedDeadEnd(t *testing.T) {
+	events := Events{
+		{Name: "submit", Src: []string{"draft"}, Dst: "review"},
+		{Name: "approve", Src: []string{"review"}, Dst: "pubilshed"},
+	}
+	if _, err := NewCheckedStateMachine("draft", events, Handlers{}); err != nil {
+		t.Fatalf("expected dead ends to be allowed by default, got %v", err)
+	}
+	_, err := NewCheckedStateMachine("draft", events, Handlers{}, WithDeadEndCheck())
+	if err == nil || err.Error() != "state pubilshed has no outgoing transitions and is not marked Terminal" {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	_, err = NewCheckedStateMachine(
+		"draft",
+		Events{
+			{Name: "submit", Src: []string{"draft"}, Dst: "review"},
+			{Name: "approve", Src: []string{"review"}, Dst: "published", Terminal: true},
+			{Name: "start", Src: []string{"draft"}, Dst: "active.running"},
+			{Name: "stop", Src: []string{"active"}, Dst: "draft"},
+		},
+		Handlers{},
+		WithDeadEndCheck(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIsDeterministic(t *testing.T) {
+	fsm := NewStateMachine(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+			{Name: "run", Src: []string{"start", "idle"}, Dst: "alt_end"},
+			{Name: "stop", Src: []string{"start"}, Dst: "idle"},
+			{Name: "stop", Src: []string{"start"}, Dst: "stopped", Guard: func(e *Event) bool {
+				return true
+			}},
+		},
+		Handlers{},
+	)
+	ok, offending := fsm.IsDeterministic()
+	if ok || len(offending) != 2 {
+		t.Fatalf("expected two offending transitions, got %v", offending)
+	}
+	for i, dst := range []string{"end", "alt_end"} {
+		if d := offending[i]; d.Name != "run" || fmt.Sprint(d.Src) != "[start]" || d.Dst != dst {
+			t.Fatalf("expected run from start to %s, got %v", dst, d)
+		}
+	}
+
+	fsm = NewStateMachine(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+			{Name: "roll", Src: []string{"start"}, Dst: "heads", Weight: 1},
+			{Name: "roll", Src: []string{"start"}, Dst: "tails", Weight: 1},
+		},
+		Handlers{},
+	)
+	if ok, offending := fsm.IsDeterministic(); !ok || offending != nil {
+		t.Fatalf("expected a deterministic machine, got %v", offending)
+	}
+}
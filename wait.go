@@ -0,0 +1,99 @@
+package statemachine
+
+import (
+	"context"
+	"sync"
+)
+
+// WaitForState blocks until the machine is in state. It returns immediately if
+// the machine already is. Any number of goroutines may wait at the same time;
+// all of them are woken when the state changes.
+//
+// A waiter only notices the states the machine is in when it is woken, so a
+// state that is left again right away by a queued or chained event may be
+// missed.
+func (machine *StateMachine) WaitForState(state string) {
+	machine.stateMu.Lock()
+	defer machine.stateMu.Unlock()
+	for machine.current != state {
+		machine.stateCond.Wait()
+	}
+}
+
+// WaitForStateContext is like WaitForState but gives up and returns the
+// context's error when ctx is done.
+func (machine *StateMachine) WaitForStateContext(ctx context.Context, state string) error {
+	stop := context.AfterFunc(ctx, func() {
+		machine.stateMu.Lock()
+		defer machine.stateMu.Unlock()
+		machine.stateCond.Broadcast()
+	})
+	defer stop()
+
+	machine.stateMu.Lock()
+	defer machine.stateMu.Unlock()
+	for machine.current != state {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		machine.stateCond.Wait()
+	}
+	return nil
+}
+
+// Watch returns a channel that receives every transition committed from now
+// on, and a function that stops the delivery and closes the channel. Unlike
+// handlers, the channel can be read from any goroutine.
+//
+// Transitions are never waited for: if the channel already holds buffer
+// transitions, the oldest one is dropped to make room, so that a slow reader
+// cannot stall the machine and always sees the latest transitions. A buffer
+// less than 1 is treated as 1. Transitions undone by WithEnterRollback are
+// not delivered.
+func (machine *StateMachine) Watch(buffer int) (<-chan Transition, func()) {
+	if buffer < 1 {
+		buffer = 1
+	}
+	ch := make(chan Transition, buffer)
+
+	machine.watchMu.Lock()
+	machine.watchers = append(machine.watchers, ch)
+	machine.watchMu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			machine.watchMu.Lock()
+			defer machine.watchMu.Unlock()
+			for i, watcher := range machine.watchers {
+				if watcher == ch {
+					machine.watchers = append(machine.watchers[:i], machine.watchers[i+1:]...)
+					break
+				}
+			}
+			close(ch)
+		})
+	}
+}
+
+// notify sends t to the channels returned by Watch, dropping their oldest
+// transition if they are full.
+func (machine *StateMachine) notify(t Transition) {
+	machine.watchMu.Lock()
+	defer machine.watchMu.Unlock()
+	for _, ch := range machine.watchers {
+		select {
+		case ch <- t:
+			continue
+		default:
+		}
+		// The channel is full. Only notify sends to it, so once the oldest
+		// transition is gone, whether dropped here or read meanwhile, there
+		// is room.
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- t
+	}
+}
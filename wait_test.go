@@ -0,0 +1,165 @@
+package statemachine
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWaitForState(t *testing.T) {
+	fsm := NewStateMachine(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+			{Name: "close", Src: []string{"open"}, Dst: "closed"},
+		},
+		Handlers{},
+	)
+
+	var ready, done sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		ready.Add(1)
+		done.Add(1)
+		go func() {
+			defer done.Done()
+			ready.Done()
+			fsm.WaitForState("open")
+		}()
+	}
+	for i := 0; i < 5; i++ {
+		done.Add(1)
+		go func() {
+			defer done.Done()
+			if err := fsm.WaitForStateContext(context.Background(), "open"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	ready.Wait()
+
+	if err := fsm.Event("open"); err != nil {
+		t.Fatal(err)
+	}
+
+	finished := make(chan struct{})
+	go func() {
+		done.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("waiters were not woken")
+	}
+}
+
+func TestWaitForStateCurrent(t *testing.T) {
+	fsm := NewStateMachine(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+		},
+		Handlers{},
+	)
+	fsm.WaitForState("closed")
+	if err := fsm.WaitForStateContext(context.Background(), "closed"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWaitForStateContextCanceled(t *testing.T) {
+	fsm := NewStateMachine(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+		},
+		Handlers{},
+	)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := fsm.WaitForStateContext(ctx, "open"); err != context.DeadlineExceeded {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+}
+
+func TestWaitForStateContextFromOtherGoroutine(t *testing.T) {
+	fsm := NewStateMachine(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+			{Name: "close", Src: []string{"open"}, Dst: "closed"},
+		},
+		Handlers{},
+	)
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		fsm.Event("open")
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := fsm.WaitForStateContext(ctx, "open"); err != nil {
+		t.Fatal(err)
+	}
+	if !fsm.Is("open") {
+		t.Fatalf("expected open, got %s", fsm.Current())
+	}
+}
+
+func TestWatch(t *testing.T) {
+	fsm := NewStateMachine(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+			{Name: "close", Src: []string{"open"}, Dst: "closed"},
+		},
+		Handlers{},
+	)
+	ch, cancel := fsm.Watch(4)
+
+	received := make(chan []string)
+	go func() {
+		var events []string
+		for transition := range ch {
+			events = append(events, transition.Event+" "+transition.Dst)
+		}
+		received <- events
+	}()
+
+	fsm.Event("open")
+	fsm.Event("close")
+	cancel()
+	cancel()
+	fsm.Event("open")
+
+	events := <-received
+	if len(events) != 2 || events[0] != "open open" || events[1] != "close closed" {
+		t.Fatalf("expected open and close, got %v", events)
+	}
+}
+
+func TestWatchDropsOldest(t *testing.T) {
+	fsm := NewStateMachine(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+			{Name: "close", Src: []string{"open"}, Dst: "closed"},
+		},
+		Handlers{},
+	)
+	ch, cancel := fsm.Watch(1)
+	defer cancel()
+
+	fsm.Event("open")
+	fsm.Event("close")
+	if transition := <-ch; transition.Event != "close" {
+		t.Fatalf("expected the latest transition, got %v", transition)
+	}
+	select {
+	case transition := <-ch:
+		t.Fatalf("expected no more transitions, got %v", transition)
+	default:
+	}
+}
@@ -0,0 +1,56 @@
+package statemachine
+
+import (
+	"math/rand"
+)
+
+// EnableWeighted makes the machine choose randomly between the transitions
+// without a Guard that share an event and source, in proportion to their
+// Weight, for example to simulate a workflow many times. Transitions with a
+// Guard are still tried first, in order, as long as they come before the
+// guardless ones. Random numbers are drawn from source, which makes runs
+// repeatable; if source is nil one seeded from the clock of the machine is
+// used. Machines without EnableWeighted always take the first transition.
+//
+// DryRun and CanGuarded draw from the same source, so they may pick a
+// different transition than a following Event.
+func (machine *StateMachine) EnableWeighted(source rand.Source) {
+	if source == nil {
+		source = rand.NewSource(machine.clock.Now().UnixNano())
+	}
+	machine.random = rand.New(source)
+}
+
+// pickWeighted returns one of the guardless candidates chosen randomly in
+// proportion to their weights. A zero Weight counts as 1 and a negative one
+// as 0.
+func (machine *StateMachine) pickWeighted(candidates []*EventDesc) *EventDesc {
+	var total float64
+	var guardless []*EventDesc
+	for _, desc := range candidates {
+		if desc.Guard == nil {
+			total += weight(desc)
+			guardless = append(guardless, desc)
+		}
+	}
+
+	r := machine.random.Float64() * total
+	for _, desc := range guardless {
+		r -= weight(desc)
+		if r < 0 {
+			return desc
+		}
+	}
+	return guardless[0]
+}
+
+// weight returns the effective weight of desc.
+func weight(desc *EventDesc) float64 {
+	switch {
+	case desc.Weight == 0:
+		return 1
+	case desc.Weight < 0:
+		return 0
+	}
+	return desc.Weight
+}
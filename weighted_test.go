@@ -0,0 +1,77 @@
+package statemachine
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestWeighted(t *testing.T) {
+	fsm := NewStateMachine(
+		"start",
+		Events{
+			{Name: "roll", Src: []string{"start"}, Dst: "rare", Weight: 1},
+			{Name: "roll", Src: []string{"start"}, Dst: "common", Weight: 3},
+			{Name: "reset", Src: []string{"rare", "common"}, Dst: "start"},
+		},
+		Handlers{},
+	)
+	fsm.EnableWeighted(rand.NewSource(1))
+
+	const runs = 10000
+	counts := make(map[string]int)
+	for i := 0; i < runs; i++ {
+		if err := fsm.Event("roll"); err != nil {
+			t.Fatal(err)
+		}
+		counts[fsm.Current()]++
+		fsm.Event("reset")
+	}
+	if share := float64(counts["common"]) / runs; share < 0.72 || share > 0.78 {
+		t.Fatalf("expected common about 75%% of the time, got %v", counts)
+	}
+}
+
+func TestWeightedGuardFirst(t *testing.T) {
+	fsm := NewStateMachine(
+		"start",
+		Events{
+			{Name: "roll", Src: []string{"start"}, Dst: "forced", Guard: func(e *Event) bool {
+				return len(e.Args) > 0
+			}},
+			{Name: "roll", Src: []string{"start"}, Dst: "random"},
+		},
+		Handlers{},
+	)
+	fsm.EnableWeighted(rand.NewSource(1))
+	fsm.Event("roll", "force")
+	if fsm.Current() != "forced" {
+		t.Fatalf("expected forced, got %s", fsm.Current())
+	}
+}
+
+func TestWeightedJSON(t *testing.T) {
+	fsm := NewStateMachine(
+		"start",
+		Events{
+			{Name: "roll", Src: []string{"start"}, Dst: "rare", Weight: 1},
+			{Name: "roll", Src: []string{"start"}, Dst: "common", Weight: 3},
+		},
+		Handlers{},
+	)
+	data, err := fsm.DefinitionJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := NewStateMachineFromJSON(data, Handlers{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, desc := range loaded.Transitions() {
+		if expected := map[string]float64{"rare": 1, "common": 3}[desc.Dst]; desc.Weight != expected {
+			t.Fatalf("expected weight %v to %s, got %v", expected, desc.Dst, desc.Weight)
+		}
+	}
+	if ok, offending := loaded.IsDeterministic(); !ok {
+		t.Fatalf("expected the weighted transitions to be kept, got %v", offending)
+	}
+}